@@ -0,0 +1,262 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+)
+
+type TreeShape int
+
+const (
+	Regular         TreeShape = iota // every level is full up to Depth, fan-out in [MinFanOut, MaxFanOut]
+	RandomRecursive                  // every new node is attached to a uniformly chosen existing node
+	Preferential                     // new nodes prefer parents with many children (heavy-tailed fan-out)
+)
+
+type Distribution int
+
+const (
+	Uniform   Distribution = iota // uniform on [0, 2 × MeanWeight]
+	Zipf                          // weight of the node of rank k is proportional to 1/k^ZipfExponent
+	LogNormal                     // log-normal with the given Sigma
+)
+
+type Config struct {
+	Seed int64
+
+	Shape     TreeShape
+	Nodes     int // number of nodes for RandomRecursive and Preferential
+	Depth     int // depth of Regular tree, also the depth limit of random trees if > 0
+	MinFanOut int // Regular only
+	MaxFanOut int // Regular only
+
+	Epochs       int
+	Weights      Distribution
+	MeanWeight   float64 // mean weight of a node per epoch
+	ZipfExponent float64
+	Sigma        float64
+	Noise        float64 // relative standard deviation of per-epoch noise
+
+	EpochsPerDay    int     // period of daily seasonality, 0 disables seasonality
+	DailyAmplitude  float64 // 0 <= amplitude < 1
+	WeeklyAmplitude float64 // 0 <= amplitude < 1, period is 7 days
+
+	Growth float64 // relative growth of weights per epoch, at least -1
+
+	SpikeProbability float64 // probability that a node starts a spike in an epoch
+	SpikeFactor      float64 // weight multiplier during a spike
+	SpikeLength      int     // number of epochs a spike lasts, not negative
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Seed:             1,
+		Shape:            RandomRecursive,
+		Nodes:            1000,
+		MinFanOut:        2,
+		MaxFanOut:        5,
+		Epochs:           100,
+		Weights:          LogNormal,
+		MeanWeight:       100,
+		ZipfExponent:     1.1,
+		Sigma:            1,
+		Noise:            0.1,
+		EpochsPerDay:     24,
+		DailyAmplitude:   0.3,
+		WeeklyAmplitude:  0.1,
+		SpikeFactor:      5,
+		SpikeLength:      3,
+		SpikeProbability: 0.001,
+	}
+}
+
+func (cfg Config) validate() error {
+	switch {
+	case cfg.Shape == Regular && (cfg.Depth < 0 || cfg.MinFanOut < 0 || cfg.MaxFanOut < cfg.MinFanOut):
+		return errors.New("generator : invalid depth or fan-out")
+	case cfg.Shape != Regular && cfg.Nodes <= 0:
+		return errors.New("generator : number of nodes must be positive")
+	case cfg.Epochs < 0 || cfg.MeanWeight < 0:
+		return errors.New("generator : invalid number of epochs or mean weight")
+	case cfg.Weights == Zipf && cfg.ZipfExponent <= 0:
+		return errors.New("generator : zipf exponent must be positive")
+	case cfg.DailyAmplitude < 0 || cfg.DailyAmplitude >= 1 || cfg.WeeklyAmplitude < 0 || cfg.WeeklyAmplitude >= 1:
+		return errors.New("generator : seasonality amplitude must be in [0, 1)")
+	case cfg.SpikeProbability < 0 || cfg.SpikeProbability > 1:
+		return errors.New("generator : spike probability must be in [0, 1]")
+	case cfg.Growth < -1:
+		return errors.New("generator : growth must be at least -1")
+	case cfg.SpikeLength < 0:
+		return errors.New("generator : spike length must not be negative")
+	}
+
+	return nil
+}
+
+// Generate builds a random hierarchy with weights.
+// The same config always produces the same hierarchy.
+func Generate(cfg Config) (*hierarchy.Hierarchy, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	var r = rand.New(rand.NewSource(cfg.Seed))
+
+	var parents []int
+	switch cfg.Shape {
+	case Regular:
+		parents = regularTree(r, cfg)
+	case RandomRecursive:
+		parents = randomRecursiveTree(r, cfg, false)
+	case Preferential:
+		parents = randomRecursiveTree(r, cfg, true)
+	default:
+		return nil, fmt.Errorf("generator : unknown tree shape %d", cfg.Shape)
+	}
+
+	var names = make([]string, len(parents))
+	for idx := range names {
+		names[idx] = fmt.Sprintf("node%d", idx)
+	}
+
+	var newHierarchy hierarchy.Hierarchy
+	newHierarchy.ChildToParent = make(map[string]string, len(parents))
+	for child, parent := range parents {
+		newHierarchy.ChildToParent[names[child]] = names[parent]
+	}
+
+	newHierarchy.WeightsPerEpoch = weightsPerEpoch(r, cfg, names)
+	newHierarchy.Range = hierarchy.EpochRange{First: 0, Last: cfg.Epochs - 1}
+
+	return &newHierarchy, nil
+}
+
+// return parent index of every node, parent of the root (node 0) is the root
+func regularTree(r *rand.Rand, cfg Config) []int {
+	var parents = []int{0}
+	var level = []int{0}
+
+	for depth := 0; depth < cfg.Depth; depth++ {
+		var nextLevel = make([]int, 0)
+		for _, parent := range level {
+			var fanOut = cfg.MinFanOut + r.Intn(cfg.MaxFanOut-cfg.MinFanOut+1)
+			for i := 0; i < fanOut; i++ {
+				nextLevel = append(nextLevel, len(parents))
+				parents = append(parents, parent)
+			}
+		}
+		level = nextLevel
+	}
+
+	return parents
+}
+
+func randomRecursiveTree(r *rand.Rand, cfg Config, preferential bool) []int {
+	var parents = make([]int, 1, cfg.Nodes)
+	var depths = make([]int, 1, cfg.Nodes)
+	// nodes below the depth limit, preferential: a node appears once plus once per child
+	var candidates = []int{0}
+
+	for len(parents) < cfg.Nodes {
+		var parent = candidates[r.Intn(len(candidates))]
+
+		var child = len(parents)
+		parents = append(parents, parent)
+		depths = append(depths, depths[parent]+1)
+		if cfg.Depth <= 0 || depths[child] < cfg.Depth {
+			candidates = append(candidates, child)
+		}
+		if preferential {
+			candidates = append(candidates, parent)
+		}
+	}
+
+	return parents
+}
+
+func weightsPerEpoch(r *rand.Rand, cfg Config, names []string) []map[string]int64 {
+	var base = baseWeights(r, cfg, len(names))
+
+	var spikeEnds = make([]int, len(names)) // epoch when the current spike of the node ends
+	var weights = make([]map[string]int64, cfg.Epochs)
+	for epoch := range weights {
+		weights[epoch] = make(map[string]int64)
+
+		var trend = seasonality(cfg, epoch) * math.Pow(1+cfg.Growth, float64(epoch))
+		for idx, name := range names {
+			var w = base[idx] * trend * (1 + cfg.Noise*r.NormFloat64())
+
+			if cfg.SpikeProbability > 0 && spikeEnds[idx] <= epoch && r.Float64() < cfg.SpikeProbability {
+				spikeEnds[idx] = epoch + cfg.SpikeLength
+			}
+			if epoch < spikeEnds[idx] {
+				w *= cfg.SpikeFactor
+			}
+
+			if weight := int64(math.Round(w)); weight > 0 {
+				weights[epoch][name] = weight
+			}
+		}
+	}
+
+	return weights
+}
+
+// return weight of every node per epoch before seasonality, trend and noise
+func baseWeights(r *rand.Rand, cfg Config, n int) []float64 {
+	var base = make([]float64, n)
+
+	switch cfg.Weights {
+	case Uniform:
+		for idx := range base {
+			base[idx] = 2 * cfg.MeanWeight * r.Float64()
+		}
+
+	case Zipf:
+		var harmonic float64
+		for k := 1; k <= n; k++ {
+			harmonic += 1 / math.Pow(float64(k), cfg.ZipfExponent)
+		}
+		for rank, idx := range r.Perm(n) {
+			base[idx] = cfg.MeanWeight * float64(n) / math.Pow(float64(rank+1), cfg.ZipfExponent) / harmonic
+		}
+
+	case LogNormal:
+		var mu = math.Log(cfg.MeanWeight) - cfg.Sigma*cfg.Sigma/2
+		for idx := range base {
+			base[idx] = math.Exp(mu + cfg.Sigma*r.NormFloat64())
+		}
+	}
+
+	return base
+}
+
+func seasonality(cfg Config, epoch int) float64 {
+	if cfg.EpochsPerDay <= 0 {
+		return 1
+	}
+
+	var day = float64(epoch) / float64(cfg.EpochsPerDay)
+	return (1 + cfg.DailyAmplitude*math.Sin(2*math.Pi*day)) *
+		(1 + cfg.WeeklyAmplitude*math.Sin(2*math.Pi*day/7))
+}
+
+var shapeNames = map[string]TreeShape{"regular": Regular, "recursive": RandomRecursive, "preferential": Preferential}
+var distributionNames = map[string]Distribution{"uniform": Uniform, "zipf": Zipf, "lognormal": LogNormal}
+
+func ParseTreeShape(name string) (TreeShape, error) {
+	if shape, ok := shapeNames[name]; ok {
+		return shape, nil
+	}
+	return 0, fmt.Errorf("generator : unknown tree shape %q, expected regular, recursive or preferential", name)
+}
+
+func ParseDistribution(name string) (Distribution, error) {
+	if distribution, ok := distributionNames[name]; ok {
+		return distribution, nil
+	}
+	return 0, fmt.Errorf("generator : unknown distribution %q, expected uniform, zipf or lognormal", name)
+}
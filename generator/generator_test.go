@@ -0,0 +1,61 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateIsReproducible(t *testing.T) {
+	for _, shape := range []TreeShape{Regular, RandomRecursive, Preferential} {
+		var cfg = DefaultConfig()
+		cfg.Shape, cfg.Depth, cfg.Nodes, cfg.Epochs = shape, 3, 200, 10
+
+		var first, err = Generate(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := Generate(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("shape %d: different hierarchies of the same config", shape)
+		}
+
+		cfg.Seed++
+		third, _ := Generate(cfg)
+		if reflect.DeepEqual(first.WeightsPerEpoch, third.WeightsPerEpoch) {
+			t.Errorf("shape %d: the same weights of different seeds", shape)
+		}
+	}
+}
+
+func TestRandomTreeDepthLimit(t *testing.T) {
+	for _, shape := range []TreeShape{RandomRecursive, Preferential} {
+		for _, depth := range []int{1, 2, 5} {
+			var cfg = DefaultConfig()
+			cfg.Shape, cfg.Depth, cfg.Nodes, cfg.Epochs = shape, depth, 100000, 0
+
+			var h, err = Generate(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(h.ChildToParent) != cfg.Nodes {
+				t.Fatalf("shape %d, depth %d: %d nodes", shape, depth, len(h.ChildToParent))
+			}
+			var maxDepth int
+			for node := range h.ChildToParent {
+				var d int
+				for ; h.ChildToParent[node] != node; node = h.ChildToParent[node] {
+					d++
+				}
+				if d > maxDepth {
+					maxDepth = d
+				}
+			}
+			if maxDepth != depth {
+				t.Errorf("shape %d: depth %d, want %d", shape, maxDepth, depth)
+			}
+		}
+	}
+}
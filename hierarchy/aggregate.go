@@ -0,0 +1,469 @@
+package hierarchy
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// Aggregation computes the load of a node from its weights in a window of epochs.
+type Aggregation int
+
+const (
+	AggregateSum  Aggregation = iota // sum of weights
+	AggregateMean                    // mean weight of the epochs in the window
+	AggregateMax                     // maximum weight
+	AggregateP95                     // 95th percentile of weights, nearest rank
+	AggregateEWMA                    // exponentially weighted moving average with span of the window size
+)
+
+func ParseAggregation(value string) (Aggregation, error) {
+	switch value {
+	case "sum":
+		return AggregateSum, nil
+	case "mean":
+		return AggregateMean, nil
+	case "max":
+		return AggregateMax, nil
+	case "p95":
+		return AggregateP95, nil
+	case "ewma":
+		return AggregateEWMA, nil
+	}
+	return AggregateSum, errors.New("hierarchy : unknown aggregation '" + value + "'")
+}
+
+// LoadDelta is the change of the load of a node made by the last push to a window.
+type LoadDelta struct {
+	Name   string
+	Weight int64
+	Extra  []int64 // changes of additional resources, nil for a single resource
+}
+
+// return weight of the resource, 0 is the first one
+func (epoch *Epoch) value(name string, resource int) int64 {
+	if resource == 0 {
+		return epoch.Weights[name]
+	}
+
+	var extra = epoch.Extra[name]
+	if resource-1 < len(extra) {
+		return extra[resource-1]
+	}
+	return 0
+}
+
+// return loads of all resources of the node, the first one goes first
+func (w *EpochWindow) aggregate(name string) []int64 {
+	var loads = make([]int64, 1+w.resources)
+
+	if w.Aggregation == AggregateEWMA {
+		if state := w.ewma[name]; state != nil {
+			for r, value := range state.current(w.pushes, w.ewmaDecay()) {
+				loads[r] = int64(math.Round(value))
+			}
+		}
+		return loads
+	}
+
+	// epochs missing from a window which is not full yet count as epochs
+	// without activity, so only pushed and evicted nodes change their loads
+	var values = make([]int64, w.Size)
+	for r := range loads {
+		for idx := range values {
+			values[idx] = 0
+			if idx < len(w.epochs) {
+				values[idx] = w.epochs[idx].value(name, r)
+			}
+		}
+		loads[r] = aggregateValues(values, w.Aggregation)
+	}
+
+	return loads
+}
+
+func aggregateValues(values []int64, aggregation Aggregation) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var result int64
+	switch aggregation {
+	case AggregateSum, AggregateMean:
+		for _, value := range values {
+			result += value
+		}
+		if aggregation == AggregateMean {
+			result = int64(math.Round(float64(result) / float64(len(values))))
+		}
+
+	case AggregateMax:
+		result = values[0]
+		for _, value := range values[1:] {
+			if value > result {
+				result = value
+			}
+		}
+
+	case AggregateP95:
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		var rank = int(math.Ceil(0.95 * float64(len(values))))
+		result = values[rank-1]
+	}
+
+	return result
+}
+
+//---------------------------Moving average----------------------
+
+// ewmaEpsilon is the average below which the state of a node is dropped.
+const ewmaEpsilon = 1e-6
+
+// ewmaState is the moving average of a node. Between epochs with weights
+// of the node the average only decays, so it is kept as of the last such
+// epoch and decayed when read.
+type ewmaState struct {
+	name    string
+	average []float64 // averages of all resources after push number at
+	at      int
+	due     int // next push which changes the rounded averages
+	index   int // position in the due queue, -1 if not queued
+}
+
+// return averages of all resources after push number now
+func (s *ewmaState) current(now int, decay float64) []float64 {
+	var factor = math.Pow(decay, float64(now-s.at))
+	var average = make([]float64, len(s.average))
+	for r, value := range s.average {
+		average[r] = value * factor
+	}
+
+	return average
+}
+
+// return the first push after now which changes the rounded averages
+// or makes them all smaller than ewmaEpsilon, 0 if they are smaller already
+func (s *ewmaState) next(now int, decay float64) int {
+	var after = now - s.at
+	var change, fade = 0, 0.0
+	for _, value := range s.average {
+		value = math.Abs(value)
+		fade = math.Max(fade, value)
+
+		var load = math.Round(value * math.Pow(decay, float64(after)))
+		if load < 1 {
+			continue
+		}
+		if k := decaysBelow(value, decay, load-0.5, after); change == 0 || k < change {
+			change = k
+		}
+	}
+
+	if change == 0 {
+		if fade*math.Pow(decay, float64(after)) < ewmaEpsilon {
+			return 0
+		}
+		change = decaysBelow(fade, decay, ewmaEpsilon, after)
+	}
+	return s.at + change
+}
+
+// return the smallest k > after such that value * decay^k < threshold
+func decaysBelow(value float64, decay float64, threshold float64, after int) int {
+	var k = after + 1
+	if decay > 0 {
+		if guess := int(math.Log(threshold/value) / math.Log(decay)); guess > k {
+			k = guess
+		}
+	}
+	for k > after+1 && value*math.Pow(decay, float64(k-1)) < threshold {
+		k--
+	}
+	for value*math.Pow(decay, float64(k)) >= threshold {
+		k++
+	}
+
+	return k
+}
+
+// ewmaQueue orders states by the next push which changes their rounded averages.
+type ewmaQueue []*ewmaState
+
+func (q ewmaQueue) Len() int           { return len(q) }
+func (q ewmaQueue) Less(i, j int) bool { return q[i].due < q[j].due }
+func (q ewmaQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *ewmaQueue) Push(x interface{}) {
+	var state = x.(*ewmaState)
+	state.index = len(*q)
+	*q = append(*q, state)
+}
+
+func (q *ewmaQueue) Pop() interface{} {
+	var old = *q
+	var state = old[len(old)-1]
+	old[len(old)-1] = nil
+	state.index = -1
+	*q = old[:len(old)-1]
+
+	return state
+}
+
+// return the weight of the newest epoch in the averages
+func (w *EpochWindow) ewmaAlpha() float64 {
+	if w.Size > 1 {
+		return 2 / (float64(w.Size) + 1)
+	}
+	return 1
+}
+
+// return the factor by which averages decay every push
+func (w *EpochWindow) ewmaDecay() float64 {
+	return 1 - w.ewmaAlpha()
+}
+
+// updateEWMA moves averages of the nodes of the epoch towards their weights
+// and adds to names them and the nodes whose decaying averages change their
+// rounded values by this push. Averages start from the weights of the first epoch.
+func (w *EpochWindow) updateEWMA(epoch *Epoch, names map[string]bool) {
+	if w.ewma == nil {
+		w.ewma = make(map[string]*ewmaState)
+	}
+	var alpha = w.ewmaAlpha()
+	var decay = 1 - alpha
+	w.pushes++
+
+	for name := range epoch.Weights {
+		var state = w.ewma[name]
+		if state == nil {
+			state = &ewmaState{name: name, at: w.pushes - 1, index: -1}
+			w.ewma[name] = state
+		}
+
+		var average = state.current(w.pushes-1, decay)
+		for len(average) < 1+w.resources {
+			average = append(average, 0)
+		}
+		for r := range average {
+			var value = float64(epoch.value(name, r))
+			if w.pushes == 1 {
+				average[r] = value
+			} else {
+				average[r] = alpha*value + decay*average[r]
+			}
+		}
+		state.average, state.at = average, w.pushes
+		names[name] = true
+	}
+
+	for len(w.due) > 0 && w.due[0].due <= w.pushes {
+		names[heap.Pop(&w.due).(*ewmaState).name] = true
+	}
+}
+
+// schedule queues the state of the node for the next push which changes it,
+// or drops the state when the averages are too small to be seen.
+func (w *EpochWindow) schedule(name string) {
+	var state = w.ewma[name]
+	if state == nil {
+		return
+	}
+
+	state.due = state.next(w.pushes, w.ewmaDecay())
+	switch {
+	case state.due == 0:
+		if state.index >= 0 {
+			heap.Remove(&w.due, state.index)
+		}
+		delete(w.ewma, name)
+	case state.index >= 0:
+		heap.Fix(&w.due, state.index)
+	default:
+		heap.Push(&w.due, state)
+	}
+}
+
+// updateLoads recomputes loads of the nodes which might change by the push of epoch.
+func (w *EpochWindow) updateLoads(epoch *Epoch, evicted *Epoch) {
+	for _, extra := range epoch.Extra {
+		if len(extra) > w.resources {
+			w.resources = len(extra)
+		}
+	}
+
+	var names = make(map[string]bool)
+	for name := range epoch.Weights {
+		names[name] = true
+	}
+	if evicted != nil {
+		for name := range evicted.Weights {
+			names[name] = true
+		}
+	}
+	if w.Aggregation == AggregateEWMA {
+		w.updateEWMA(epoch, names)
+	}
+
+	var sorted = make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	w.deltas = w.deltas[:0]
+	for _, name := range sorted {
+		var loads = w.aggregate(name)
+		if w.Aggregation == AggregateEWMA {
+			w.schedule(name)
+		}
+
+		var delta = LoadDelta{Name: name, Weight: loads[0] - w.loads[name]}
+		var changed = delta.Weight != 0
+		if w.resources > 0 {
+			delta.Extra = make([]int64, w.resources)
+			var old = w.extraLoads[name]
+			for r := range delta.Extra {
+				delta.Extra[r] = loads[1+r]
+				if r < len(old) {
+					delta.Extra[r] -= old[r]
+				}
+				changed = changed || delta.Extra[r] != 0
+			}
+		}
+
+		if isZero(loads) {
+			delete(w.loads, name)
+			delete(w.extraLoads, name)
+		} else {
+			w.loads[name] = loads[0]
+			if w.resources > 0 {
+				w.extraLoads[name] = loads[1:]
+			}
+		}
+		if changed {
+			w.deltas = append(w.deltas, delta)
+		}
+	}
+}
+
+func isZero(values []int64) bool {
+	for _, value := range values {
+		if value != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// return changes of loads made by the last Push sorted by node name
+func (w *EpochWindow) Deltas() []LoadDelta {
+	return w.deltas
+}
+
+// return loads of all nodes having non-zero load
+func (w *EpochWindow) Loads() map[string]int64 {
+	var loads = make(map[string]int64, len(w.loads))
+	for name, load := range w.loads {
+		loads[name] = load
+	}
+
+	return loads
+}
+
+// return loads of additional resources, nil for a single resource
+func (w *EpochWindow) ExtraLoads() map[string][]int64 {
+	if w.resources == 0 {
+		return nil
+	}
+
+	var loads = make(map[string][]int64, len(w.extraLoads))
+	for name, load := range w.extraLoads {
+		loads[name] = append([]int64(nil), load...)
+	}
+
+	return loads
+}
+
+//---------------------------Resampling----------------------
+
+type resampledSource struct {
+	src     EpochSource
+	factor  int
+	pending *Epoch // first epoch of the next bucket
+}
+
+// Resample sums weights of every factor consecutive epochs, e.g. 24 hours into a day.
+// Epoch n falls into the bucket n / factor. A resampled epoch is numbered by
+// the last epoch of its bucket, so topology events of the bucket precede its weights.
+func Resample(src EpochSource, factor int) EpochSource {
+	if factor <= 1 {
+		return src
+	}
+	return &resampledSource{src: src, factor: factor}
+}
+
+func (s *resampledSource) Next() (*Epoch, error) {
+	var first = s.pending
+	s.pending = nil
+	if first == nil {
+		var err error
+		if first, err = s.src.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	var bucket = floorDiv(first.Number, s.factor)
+	var result = &Epoch{Number: first.Number, Weights: make(map[string]int64)}
+	result.merge(first)
+	for {
+		var epoch, err = s.src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if floorDiv(epoch.Number, s.factor) != bucket {
+			s.pending = epoch
+			break
+		}
+
+		result.merge(epoch)
+		result.Number = epoch.Number
+	}
+
+	return result, nil
+}
+
+func (epoch *Epoch) merge(other *Epoch) {
+	for name, weight := range other.Weights {
+		epoch.Weights[name] += weight
+	}
+	for name, extra := range other.Extra {
+		if epoch.Extra == nil {
+			epoch.Extra = make(map[string][]int64)
+		}
+		var sum = epoch.Extra[name]
+		for len(sum) < len(extra) {
+			sum = append(sum, 0)
+		}
+		for r, weight := range extra {
+			sum[r] += weight
+		}
+		epoch.Extra[name] = sum
+	}
+}
+
+func floorDiv(a int, b int) int {
+	var q = a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,94 @@
+package hierarchy
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// applyDeltas returns loads changed by the deltas of the last push
+func applyDeltas(loads map[string]int64, deltas []LoadDelta) map[string]int64 {
+	var result = make(map[string]int64, len(loads))
+	for name, load := range loads {
+		result[name] = load
+	}
+	for _, delta := range deltas {
+		result[delta.Name] += delta.Weight
+		if result[delta.Name] == 0 {
+			delete(result, delta.Name)
+		}
+	}
+	return result
+}
+
+func TestEWMAMatchesFullRecompute(t *testing.T) {
+	for _, size := range []int{1, 4, 10} {
+		var window = NewEpochWindow(size, AggregateEWMA)
+		var alpha = window.ewmaAlpha()
+		var reference = make(map[string]float64)
+		var rnd = rand.New(rand.NewSource(int64(size)))
+
+		var push = func(number int, weights map[string]int64) {
+			for node := range reference {
+				reference[node] *= 1 - alpha
+			}
+			for node, weight := range weights {
+				if number == 0 {
+					reference[node] = float64(weight)
+				} else {
+					reference[node] += alpha * float64(weight)
+				}
+			}
+
+			var before = window.Loads()
+			window.Push(&Epoch{Number: number, Weights: weights})
+
+			var expected = make(map[string]int64)
+			for node, average := range reference {
+				if load := int64(math.Round(average)); load != 0 {
+					expected[node] = load
+				}
+			}
+			if loads := window.Loads(); !reflect.DeepEqual(loads, expected) {
+				t.Fatalf("size %d, epoch %d: loads %v, expected %v", size, number, loads, expected)
+			}
+			if loads := applyDeltas(before, window.Deltas()); !reflect.DeepEqual(loads, expected) {
+				t.Fatalf("size %d, epoch %d: loads by deltas %v, expected %v", size, number, loads, expected)
+			}
+		}
+
+		for number := 0; number < 300; number++ {
+			var weights = make(map[string]int64)
+			for i := 0; i < 3; i++ {
+				var weight = rnd.Int63n(4)
+				if rnd.Intn(10) == 0 {
+					weight = rnd.Int63n(1000)
+				}
+				weights[fmt.Sprint("n", rnd.Intn(20))] = weight
+			}
+			push(number, weights)
+		}
+		for number := 300; number < 600; number++ {
+			push(number, map[string]int64{})
+		}
+
+		if len(window.ewma) != 0 || len(window.due) != 0 {
+			t.Errorf("size %d: %d averages and %d queued are left after fading out", size, len(window.ewma), len(window.due))
+		}
+	}
+}
+
+func TestMeanOfFillingWindow(t *testing.T) {
+	var window = NewEpochWindow(4, AggregateMean)
+	window.Push(&Epoch{Number: 0, Weights: map[string]int64{"a": 4}})
+	window.Push(&Epoch{Number: 1, Weights: map[string]int64{"b": 8}})
+
+	if deltas := window.Deltas(); !reflect.DeepEqual(deltas, []LoadDelta{{Name: "b", Weight: 2}}) {
+		t.Errorf("deltas %v, expected only b", deltas)
+	}
+	if loads := window.Loads(); !reflect.DeepEqual(loads, map[string]int64{"a": 1, "b": 2}) {
+		t.Errorf("loads %v", loads)
+	}
+}
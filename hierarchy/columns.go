@@ -0,0 +1,105 @@
+package hierarchy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseColumns parses a column mapping like "node:tenant_id,epoch:day,weight:requests".
+func ParseColumns(value string, fields []string) (map[string]string, error) {
+	var columns = make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		var slice = strings.SplitN(pair, ":", 2)
+		if len(slice) != 2 || slice[1] == "" {
+			return nil, errors.New("hierarchy : invalid column mapping '" + pair + "'")
+		}
+		if indexOf(fields, slice[0]) < 0 {
+			return nil, errors.New("hierarchy : unknown field '" + slice[0] + "', expected one of " +
+				strings.Join(fields, ", "))
+		}
+		columns[slice[0]] = slice[1]
+	}
+
+	return columns, nil
+}
+
+// mapColumns returns the column index of every field. A mapped field is found
+// by the header name or by the 1-based column number, an unmapped one by its own
+// name or by its default position. Fields sharing a column are rejected. Columns after the last field are appended,
+// so weights of additional resources keep following the weight column.
+func mapColumns(fields []string, columns map[string]string, header []string) ([]int, error) {
+	var indexes = make([]int, len(fields))
+	var last = -1
+	for idx, field := range fields {
+		var column, mapped = columns[field]
+		if !mapped {
+			column = field
+		}
+
+		indexes[idx] = indexOf(header, column)
+		if indexes[idx] < 0 {
+			if n, err := strconv.Atoi(column); err == nil && n > 0 {
+				indexes[idx] = n - 1
+			} else if mapped {
+				return nil, fmt.Errorf("%w %q", ErrBadColumn, column)
+			} else {
+				indexes[idx] = idx
+			}
+		}
+
+		if other := indexOfInt(indexes[:idx], indexes[idx]); other >= 0 {
+			var column = strconv.Itoa(indexes[idx] + 1)
+			if indexes[idx] < len(header) {
+				column = header[indexes[idx]]
+			}
+			return nil, fmt.Errorf("%w %q: %s and %s", ErrDuplicateColumn, column, fields[other], field)
+		}
+		if indexes[idx] > last {
+			last = indexes[idx]
+		}
+	}
+
+	for idx := last + 1; idx < len(header); idx++ {
+		indexes = append(indexes, idx)
+	}
+
+	return indexes, nil
+}
+
+// project returns fields of the record in their default order.
+// The result is shorter if a column is missing in the record.
+func (src *csvSource) project(record []string) []string {
+	if src.columns == nil || record == nil {
+		return record
+	}
+
+	var fields = make([]string, 0, len(src.columns))
+	for _, column := range src.columns {
+		if column >= len(record) {
+			break
+		}
+		fields = append(fields, record[column])
+	}
+
+	return fields
+}
+
+func indexOf(names []string, name string) int {
+	for idx := range names {
+		if names[idx] == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+func indexOfInt(values []int, value int) int {
+	for idx := range values {
+		if values[idx] == value {
+			return idx
+		}
+	}
+	return -1
+}
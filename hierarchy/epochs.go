@@ -0,0 +1,323 @@
+package hierarchy
+
+import (
+	"io"
+	"math"
+)
+
+// Epoch holds node weights of one epoch.
+type Epoch struct {
+	Number  int
+	Weights map[string]int64
+	Extra   map[string][]int64 // weights of additional resources, nil for a single resource
+}
+
+func newEpoch(number int, resources int) *Epoch {
+	var epoch = &Epoch{Number: number, Weights: make(map[string]int64)}
+	if resources > 1 {
+		epoch.Extra = make(map[string][]int64)
+	}
+
+	return epoch
+}
+
+func (epoch *Epoch) add(row *weightRow) {
+	epoch.Weights[row.node] += row.weight
+	if epoch.Extra == nil {
+		return
+	}
+
+	var extra, ok = epoch.Extra[row.node]
+	if !ok {
+		extra = make([]int64, len(row.extra))
+		epoch.Extra[row.node] = extra
+	}
+	for idx, weight := range row.extra {
+		extra[idx] += weight
+	}
+}
+
+// EpochSource yields node weights one epoch at a time,
+// so the whole weights file never has to be kept in memory.
+type EpochSource interface {
+	// Next returns the next epoch, or io.EOF after the last one.
+	Next() (*Epoch, error)
+}
+
+//---------------------------In-memory source----------------------
+
+type sliceEpochSource struct {
+	h    *Hierarchy
+	next int
+}
+
+// return EpochSource over already loaded weights
+func (h *Hierarchy) Epochs() EpochSource {
+	return &sliceEpochSource{h: h}
+}
+
+func (s *sliceEpochSource) Next() (*Epoch, error) {
+	if s.next >= len(s.h.WeightsPerEpoch) {
+		return nil, io.EOF
+	}
+
+	var epoch = &Epoch{Number: s.h.Range.First + s.next, Weights: s.h.WeightsPerEpoch[s.next]}
+	if s.h.ExtraWeightsPerEpoch != nil {
+		epoch.Extra = s.h.ExtraWeightsPerEpoch[s.next]
+	}
+	s.next++
+
+	return epoch, nil
+}
+
+//---------------------------File source----------------------
+
+// FileEpochSource reads a weights file lazily, only the rows of one epoch are kept
+// in memory. Rows must be grouped by epoch in ascending order, missing epochs are
+// returned empty, as are epochs of a wide header before the first row and after the last one. A row whose epoch goes backwards is reported as ErrEpochOrder.
+// With Options.InMemory the whole file is read when it is opened instead, and rows
+// may come in any order.
+type FileEpochSource struct {
+	src        weightSource
+	memory     *sliceEpochSource // weights read with Options.InMemory, nil if the file is streamed
+	resources  []string
+	epoch      int        // epoch returned by the next call of Next
+	epochRange EpochRange // epochs returned so far
+	header     EpochRange // epochs listed by the file up front, returned even without rows
+	lastEpoch  int        // epoch of the last accepted row
+	pending    *weightRow // first row of the next epoch
+	errs       error      // errors collected by Options.CollectAll, returned after the last epoch
+	started    bool
+	eof        bool
+}
+
+// OpenEpochSource opens a weights file in the format given by Options.Format
+// or by the file extension.
+func OpenEpochSource(weightsPerEpoch string, opts Options) (*FileEpochSource, error) {
+	var src, err = openWeightSource(weightsPerEpoch, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.InMemory {
+		var h Hierarchy
+		err = readWeights(src, opts, &h)
+		_ = src.close()
+		if _, ok := err.(ErrorList); err != nil && !ok {
+			return nil, err
+		}
+		return &FileEpochSource{memory: &sliceEpochSource{h: &h}, resources: h.Resources,
+			epochRange: newEpochRange(opts), errs: err}, nil
+	}
+
+	var s = &FileEpochSource{src: src, resources: src.resources(), epochRange: newEpochRange(opts),
+		header: newEpochRange(opts), lastEpoch: math.MinInt}
+	if header, ok := src.(epochHeader); ok {
+		s.header = header.headerRange()
+	}
+	return s, nil
+}
+
+// NewCsvEpochSource opens a weights file in CSV format regardless of its extension.
+func NewCsvEpochSource(csvWeightsPerEpoch string, opts Options) (*FileEpochSource, error) {
+	opts.Format = FormatCsv
+	return OpenEpochSource(csvWeightsPerEpoch, opts)
+}
+
+// Next returns weights of the next epoch. When rows are being collected
+// (Options.CollectAll), the ErrorList is returned once after the last epoch.
+func (s *FileEpochSource) Next() (*Epoch, error) {
+	var epoch, err = s.next()
+	if err == io.EOF && s.errs != nil {
+		err, s.errs = s.errs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.epochRange.add(epoch.Number)
+	return epoch, nil
+}
+
+func (s *FileEpochSource) next() (*Epoch, error) {
+	if s.memory != nil {
+		return s.memory.Next()
+	}
+
+	if s.pending == nil {
+		if err := s.readPending(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if s.pending == nil && (s.header.Len() == 0 || s.started && s.epoch > s.header.Last) {
+		return nil, io.EOF
+	}
+	if !s.started {
+		s.epoch = s.header.First
+		if s.pending != nil && (s.header.Len() == 0 || s.pending.epoch < s.epoch) {
+			s.epoch = s.pending.epoch
+		}
+		s.started = true
+	}
+
+	var epoch = newEpoch(s.epoch, len(s.resources))
+	for s.pending != nil && s.pending.epoch == s.epoch {
+		epoch.add(s.pending)
+
+		if err := s.readPending(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	s.epoch++
+
+	return epoch, nil
+}
+
+// return names of weight columns
+func (s *FileEpochSource) Resources() []string {
+	return s.resources
+}
+
+// return epochs read so far
+func (s *FileEpochSource) Range() EpochRange {
+	return s.epochRange
+}
+
+// readPending reads the first row of the next epoch, errors collected
+// by Options.CollectAll are kept for Next at the end of the file.
+// Rows going back to an earlier epoch are reported, or skipped when collected.
+func (s *FileEpochSource) readPending() error {
+	s.pending = nil
+	if s.eof {
+		return io.EOF
+	}
+
+	for {
+		var row, err = s.src.readWeightRow()
+		if err == io.EOF {
+			s.eof = true
+			s.errs = s.src.takeErrors()
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			continue
+		}
+		if row.epoch < s.lastEpoch {
+			if err = s.src.failEpoch(ErrEpochOrder); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s.lastEpoch = row.epoch
+		s.pending = row
+		return nil
+	}
+}
+
+func (s *FileEpochSource) Close() error {
+	if s.src == nil {
+		return nil
+	}
+	return s.src.close()
+}
+
+//---------------------------Sliding window----------------------
+
+// EpochWindow keeps the last Size epochs read from a source
+// and the loads of nodes aggregated over them.
+type EpochWindow struct {
+	Size        int
+	Last        int // number of the newest epoch, -1 if there are no epochs
+	Aggregation Aggregation
+
+	epochs []*Epoch // ring buffer
+	start  int      // index of the oldest epoch
+
+	resources  int // number of additional resources
+	loads      map[string]int64
+	extraLoads map[string][]int64
+	pushes     int // number of pushed epochs, counted for EWMA only
+	ewma       map[string]*ewmaState
+	due        ewmaQueue
+	deltas     []LoadDelta
+}
+
+func NewEpochWindow(size int, aggregation Aggregation) *EpochWindow {
+	return &EpochWindow{Size: size, Last: -1, Aggregation: aggregation, epochs: make([]*Epoch, 0, size),
+		loads: make(map[string]int64), extraLoads: make(map[string][]int64)}
+}
+
+// Fill pushes the next epochs of the source into the window.
+// It stops earlier if the source ends.
+func (w *EpochWindow) Fill(src EpochSource, epochs int) error {
+	for i := 0; i < epochs; i++ {
+		var epoch, err = src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		w.Push(epoch)
+	}
+
+	return nil
+}
+
+// return weights of the window from the oldest epoch to the newest
+func (w *EpochWindow) Epochs() []map[string]int64 {
+	var weights = make([]map[string]int64, 0, len(w.epochs))
+	for _, epoch := range w.ordered() {
+		weights = append(weights, epoch.Weights)
+	}
+
+	return weights
+}
+
+// return weights of additional resources from the oldest epoch to the newest
+func (w *EpochWindow) ExtraEpochs() []map[string][]int64 {
+	var extra = make([]map[string][]int64, 0, len(w.epochs))
+	for _, epoch := range w.ordered() {
+		extra = append(extra, epoch.Extra)
+	}
+
+	return extra
+}
+
+func (w *EpochWindow) ordered() []*Epoch {
+	var epochs = make([]*Epoch, 0, len(w.epochs))
+	epochs = append(epochs, w.epochs[w.start:]...)
+	epochs = append(epochs, w.epochs[:w.start]...)
+
+	return epochs
+}
+
+// Push appends the newest epoch and returns the evicted oldest one,
+// nil if the window is not full yet. Changes of loads are returned by Deltas.
+func (w *EpochWindow) Push(epoch *Epoch) *Epoch {
+	var evicted = w.push(epoch)
+	w.updateLoads(epoch, evicted)
+
+	return evicted
+}
+
+func (w *EpochWindow) push(epoch *Epoch) *Epoch {
+	w.Last = epoch.Number
+	if w.Size == 0 {
+		return epoch
+	}
+	if len(w.epochs) < w.Size {
+		w.epochs = append(w.epochs, epoch)
+		return nil
+	}
+
+	var evicted = w.epochs[w.start]
+	w.epochs[w.start] = epoch
+	w.start = (w.start + 1) % len(w.epochs)
+
+	return evicted
+}
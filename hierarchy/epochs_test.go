@@ -0,0 +1,143 @@
+package hierarchy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	var path = filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readAll returns numbers and weights of all epochs and the first error other than io.EOF
+func readAll(t *testing.T, src *FileEpochSource) ([]int, []map[string]int64, error) {
+	t.Helper()
+	var numbers []int
+	var weights []map[string]int64
+	for {
+		var epoch, err = src.Next()
+		if err == io.EOF {
+			return numbers, weights, nil
+		}
+		if err != nil {
+			return numbers, weights, err
+		}
+		numbers = append(numbers, epoch.Number)
+		weights = append(weights, epoch.Weights)
+	}
+}
+
+func TestFileEpochSourceEpochOrder(t *testing.T) {
+	var path = writeTestFile(t, "unsorted.csv", "node,epoch,weight\na,0,1\nb,0,2\na,2,3\nb,1,5\na,3,4\n")
+
+	var src, err = OpenEpochSource(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers, _, err := readAll(t, src)
+	_ = src.Close()
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || !errors.Is(err, ErrEpochOrder) || parseErr.Line != 5 {
+		t.Errorf("error %v, want epoch out of order at line 5", err)
+	}
+	if !reflect.DeepEqual(numbers, []int{0, 1}) {
+		t.Errorf("epochs %v before the error", numbers)
+	}
+
+	src, err = OpenEpochSource(path, Options{CollectAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers, weights, err := readAll(t, src)
+	_ = src.Close()
+	var list ErrorList
+	if !errors.As(err, &list) || len(list) != 1 || !errors.Is(list[0], ErrEpochOrder) {
+		t.Errorf("error %v, want one collected epoch out of order", err)
+	}
+	if !reflect.DeepEqual(numbers, []int{0, 1, 2, 3}) || len(weights[1]) != 0 {
+		t.Errorf("epochs %v %v, want the row going backwards skipped", numbers, weights)
+	}
+}
+
+func TestFileEpochSourceInMemory(t *testing.T) {
+	var sorted = writeTestFile(t, "sorted.csv", "node,epoch,weight\na,0,1\nb,0,2\na,1,3\na,3,4\n")
+	var unsorted = writeTestFile(t, "unsorted.csv", "node,epoch,weight\na,3,4\na,0,1\na,1,3\nb,0,2\n")
+
+	var want []map[string]int64
+	for idx, path := range []string{sorted, unsorted} {
+		var src, err = OpenEpochSource(path, Options{InMemory: idx == 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers, weights, err := readAll(t, src)
+		_ = src.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(numbers, []int{0, 1, 2, 3}) {
+			t.Errorf("%s: epochs %v", path, numbers)
+		}
+		if idx == 0 {
+			want = weights
+		} else if !reflect.DeepEqual(weights, want) {
+			t.Errorf("unsorted weights %v, sorted %v", weights, want)
+		}
+		if r := src.Range(); r.First != 0 || r.Last != 3 {
+			t.Errorf("%s: range %v", path, r)
+		}
+	}
+}
+
+// a malformed row must not hide that the rest of the file is unsorted
+func TestFileEpochSourceMalformedRowBeforeUnsorted(t *testing.T) {
+	var path = writeTestFile(t, "weights.csv", "node,epoch,weight\na,x,1\na,2,1\nb,1,2\n")
+	var src, err = OpenEpochSource(path, Options{CollectAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = readAll(t, src)
+	_ = src.Close()
+	if !errors.Is(err, ErrBadEpoch) || !errors.Is(err, ErrEpochOrder) {
+		t.Errorf("error %v, want both the invalid and the backward epoch", err)
+	}
+}
+
+func TestFileEpochSourceCollectAllKeepsLastEpoch(t *testing.T) {
+	for _, test := range []struct {
+		content  string
+		inMemory bool
+	}{
+		{"node,epoch,weight\na,0,1\nb,x,2\na,5,3\n", false},
+		{"node,epoch,weight\na,5,3\nb,x,2\na,0,1\n", true},
+	} {
+		var content = test.content
+		var path = writeTestFile(t, "weights.csv", content)
+		var src, err = OpenEpochSource(path, Options{CollectAll: true, InMemory: test.inMemory})
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers, weights, err := readAll(t, src)
+		_ = src.Close()
+
+		var list ErrorList
+		if !errors.As(err, &list) || len(list) != 1 || !errors.Is(list[0], ErrBadEpoch) {
+			t.Errorf("%q: error %v, want one invalid epoch", content, err)
+		}
+		if len(numbers) != 6 || numbers[5] != 5 || weights[5]["a"] != 3 {
+			t.Errorf("%q: epochs %v %v, want epoch 5 before the errors", content, numbers, weights)
+		}
+		if _, err = src.Next(); err != io.EOF {
+			t.Errorf("%q: %v after the errors, want io.EOF", content, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package hierarchy
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMissingField    = errors.New("missing field")
+	ErrEmptyName       = errors.New("empty node name")
+	ErrBadEpoch        = errors.New("invalid epoch")
+	ErrBadWeight       = errors.New("invalid weight")
+	ErrDuplicateName   = errors.New("duplicate node name")
+	ErrNullNode        = errors.New("null node")
+	ErrEpochOrder      = errors.New("epoch out of order")
+	ErrBadColumn       = errors.New("column not found")
+	ErrDuplicateColumn = errors.New("column shared by several fields")
+)
+
+// ParseError describes one malformed row of an input file.
+type ParseError struct {
+	File   string
+	Line   int // 1-based line number, 0 if unknown
+	Column int // 1-based field number, 0 if the whole row is malformed
+	Value  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	var pos = e.File
+	if e.Line > 0 {
+		pos += fmt.Sprintf(":%d", e.Line)
+	}
+	if e.Line > 0 && e.Column > 0 {
+		pos += fmt.Sprintf(":%d", e.Column)
+	}
+
+	if e.Value != "" {
+		return fmt.Sprintf("hierarchy : %s: %v %q", pos, e.Err, e.Value)
+	}
+	return fmt.Sprintf("hierarchy : %s: %v", pos, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is returned when Options.CollectAll is set and
+// at least one row could not be parsed.
+type ErrorList []*ParseError
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "hierarchy : no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", list[0], len(list)-1)
+}
+
+func (list ErrorList) Unwrap() []error {
+	var errs = make([]error, 0, len(list))
+	for _, e := range list {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// return nil for empty list
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// merge appends collected errors to the list and
+// returns any other error unchanged.
+func (list *ErrorList) merge(err error) error {
+	if other, ok := err.(ErrorList); ok {
+		*list = append(*list, other...)
+		return nil
+	}
+	return err
+}
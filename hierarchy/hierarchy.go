@@ -1,11 +1,16 @@
 package hierarchy
 
 import (
+	"compress/gzip"
 	"encoding/csv"
+	"errors"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Hierarchy struct {
@@ -15,68 +20,562 @@ type Hierarchy struct {
 	// Key of map is node name.
 	// Value of map is the number
 	// of weight by this node per epoch.
+
+	Range EpochRange // epochs covered by WeightsPerEpoch
+
+	Resources            []string             // names of weight columns, weights of the first one are in WeightsPerEpoch
+	ExtraWeightsPerEpoch []map[string][]int64 // weights of Resources[1:] per epoch, nil for a single resource
+}
+
+type Options struct {
+	CollectAll bool // report every malformed row instead of stopping at the first one
+
+	Timestamps    bool          // epoch column holds timestamps which are bucketed into epochs
+	EpochDuration time.Duration // length of one epoch, required with Timestamps
+	EpochOrigin   time.Time     // buckets are aligned to this moment, Unix epoch by default
+
+	MultiResource bool // columns after the weight hold weights of additional resources
+
+	Format string // FormatCsv or FormatJson, chosen by file extension if empty
+
+	WideWeights bool // weights file has one row per node and one column per epoch
+
+	InMemory bool // OpenEpochSource reads all weights at once, rows may come in any order
+
+	Delimiter rune // field delimiter of CSV files, comma if zero
+	NoHeader  bool // CSV files have no header row
+
+	// Columns of CSV files by field name, e.g. "node" -> "tenant_id". A column is given
+	// by its header name or by its 1-based number. Unmapped fields keep their position.
+	TreeColumns    map[string]string // fields child, parent
+	WeightsColumns map[string]string // fields node, epoch, weight
+	EventsColumns  map[string]string // fields epoch, event, node, parent
+}
+
+// Fields of CSV files in their default order.
+var (
+	TreeFields    = []string{"child", "parent"}
+	WeightsFields = []string{"node", "epoch", "weight"}
+	EventsFields  = []string{"epoch", "event", "node", "parent"}
+)
+
+// Supported file formats.
+const (
+	FormatCsv  = "csv"
+	FormatJson = "json" // nested JSON tree and JSON Lines weights
+)
+
+func (opts Options) validate() error {
+	if opts.Timestamps && opts.EpochDuration <= 0 {
+		return errors.New("hierarchy : epoch duration must be positive")
+	}
+	if opts.Format != "" && opts.Format != FormatCsv && opts.Format != FormatJson {
+		return errors.New("hierarchy : unknown format '" + opts.Format + "'")
+	}
+	return nil
+}
+
+// FormatOf returns the format of the file: Options.Format if it is set,
+// FormatJson for .json, .jsonl and .ndjson files and FormatCsv otherwise.
+// The .gz extension of compressed files is skipped.
+func FormatOf(path string, opts Options) string {
+	if opts.Format != "" {
+		return opts.Format
+	}
+
+	path = strings.TrimSuffix(strings.ToLower(path), gzipExt)
+	switch filepath.Ext(path) {
+	case ".json", ".jsonl", ".ndjson":
+		return FormatJson
+	}
+	return FormatCsv
+}
+
+func NewHierarchy(csvChildParent string, csvWeightsPerEpoch string) (*Hierarchy, error) {
+	return NewHierarchyWithOptions(csvChildParent, csvWeightsPerEpoch, Options{})
 }
 
-func NewHierarchy(csvChildParent string, csvWeightsPerEpoch string) *Hierarchy {
+func NewHierarchyWithOptions(csvChildParent string, csvWeightsPerEpoch string, opts Options) (*Hierarchy, error) {
 	var newHierarchy Hierarchy
+	var errs ErrorList
+
+	var err error
+	newHierarchy.ChildToParent, err = ReadTreeNodes(csvChildParent, opts)
+	if err = errs.merge(err); err != nil {
+		return nil, err
+	}
+
+	err = readWeightsPerEpoch(csvWeightsPerEpoch, opts, &newHierarchy)
+	if err = errs.merge(err); err != nil {
+		return nil, err
+	}
+
+	if err = errs.Err(); err != nil {
+		return nil, err
+	}
+
+	return &newHierarchy, nil
+}
+
+// ReadTreeNodes reads only the topology part of a hierarchy.
+// If a child has several rows the last one is used.
+func ReadTreeNodes(csvChildParent string, opts Options) (map[string]string, error) {
+	if FormatOf(csvChildParent, opts) == FormatJson {
+		return readJsonTree(csvChildParent, opts)
+	}
 
-	newHierarchy.ChildToParent = readTreeNodes(csvChildParent)
-	newHierarchy.WeightsPerEpoch = readWeightsPerEpoch(csvWeightsPerEpoch)
+	var rows, err = ReadTreeRows(csvChildParent, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	return &newHierarchy
+	var childToParent = make(map[string]string, len(rows))
+	for _, row := range rows {
+		childToParent[row.Child] = row.Parent
+	}
+	return childToParent, nil
 }
 
-func readTreeNodes(csvChildParent string) map[string]string {
-	var r = newCsvReader(csvChildParent)
+// TreeRow is one row of a tree file.
+type TreeRow struct {
+	Child  string
+	Parent string
+	Line   int // 0 for JSON trees
+}
 
-	var childToParent = make(map[string]string)
+// ReadTreeRows reads all rows of a tree file including duplicate ones.
+func ReadTreeRows(csvChildParent string, opts Options) ([]TreeRow, error) {
+	if FormatOf(csvChildParent, opts) == FormatJson {
+		var childToParent, err = readJsonTree(csvChildParent, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var rows = make([]TreeRow, 0, len(childToParent))
+		for child, parent := range childToParent {
+			rows = append(rows, TreeRow{Child: child, Parent: parent})
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Child < rows[j].Child })
+		return rows, nil
+	}
+
+	var src, err = newCsvSource(csvChildParent, opts, TreeFields, opts.TreeColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+
+	var rows = make([]TreeRow, 0)
 	for {
-		record, err := r.Read()
+		record, err := src.read(2)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
+		}
+		if record == nil {
+			continue
 		}
 		childName, parentName := record[0], record[1]
 
-		childToParent[childName] = parentName
+		if childName == "" {
+			if err = src.fail(1, childName, ErrEmptyName); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if parentName == "" {
+			if err = src.fail(2, parentName, ErrEmptyName); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		rows = append(rows, TreeRow{Child: childName, Parent: parentName, Line: src.line})
+	}
+
+	if err = src.errs.Err(); err != nil {
+		return nil, err
 	}
-	return childToParent
+	return rows, nil
 }
 
-func readWeightsPerEpoch(csvWeightPerEpoch string) []map[string]int64 {
-	var r = newCsvReader(csvWeightPerEpoch)
-	var weightsPerEpoch = make([]map[string]int64, 0)
+// ReadWeights reads only the weights part of a hierarchy.
+func ReadWeights(weightsPerEpoch string, opts Options) (*Hierarchy, error) {
+	var h Hierarchy
+	if err := readWeightsPerEpoch(weightsPerEpoch, opts, &h); err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+// readWeightsPerEpoch accepts rows in any order.
+// Epochs without rows between the first and the last one are left empty.
+func readWeightsPerEpoch(csvWeightPerEpoch string, opts Options, h *Hierarchy) error {
+	var src, err = openWeightSource(csvWeightPerEpoch, opts)
+	if err != nil {
+		return err
+	}
+	defer src.close()
 
+	return readWeights(src, opts, h)
+}
+
+// readWeights reads all rows of the source into h. Errors collected
+// with Options.CollectAll are returned after h is filled with valid rows.
+func readWeights(src weightSource, opts Options, h *Hierarchy) error {
+	h.Range = newEpochRange(opts)
+	h.Resources = src.resources()
+
+	var weightsByEpoch = make(map[int]*Epoch)
 	for {
-		record, err := r.Read()
+		row, err := src.readWeightRow()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		if row == nil {
+			continue
 		}
-		var nodeName = record[0]
-		var epoch, _ = strconv.Atoi(record[1])
-		var weight, _ = strconv.ParseInt(record[2], 10, 64)
 
-		if epoch == len(weightsPerEpoch) {
-			weightsPerEpoch = append(weightsPerEpoch, make(map[string]int64))
+		if _, ok := weightsByEpoch[row.epoch]; !ok {
+			weightsByEpoch[row.epoch] = newEpoch(row.epoch, len(h.Resources))
+			h.Range.add(row.epoch)
 		}
 
-		weightsPerEpoch[epoch][nodeName] += weight
+		weightsByEpoch[row.epoch].add(row)
 	}
-	return weightsPerEpoch
+
+	if header, ok := src.(epochHeader); ok && header.headerRange().Len() > 0 {
+		h.Range.add(header.headerRange().First)
+		h.Range.add(header.headerRange().Last)
+	}
+
+	var errs = src.takeErrors() // returned with the weights of valid rows
+
+	h.WeightsPerEpoch = make([]map[string]int64, h.Range.Len())
+	if len(h.Resources) > 1 {
+		h.ExtraWeightsPerEpoch = make([]map[string][]int64, h.Range.Len())
+	}
+	for idx := range h.WeightsPerEpoch {
+		var epoch, ok = weightsByEpoch[h.Range.First+idx]
+		if !ok {
+			epoch = newEpoch(h.Range.First+idx, len(h.Resources))
+		}
+
+		h.WeightsPerEpoch[idx] = epoch.Weights
+		if h.ExtraWeightsPerEpoch != nil {
+			h.ExtraWeightsPerEpoch[idx] = epoch.Extra
+		}
+	}
+
+	return errs
+}
+
+type weightRow struct {
+	node   string
+	epoch  int // epoch number or index of timestamp bucket
+	weight int64
+	extra  []int64 // weights of additional resources
 }
 
-func newCsvReader(filepath string) *csv.Reader {
-	csvFile, err := os.Open(filepath)
+// weightSource yields rows of a weights file in one of the supported formats.
+type weightSource interface {
+	// readWeightRow returns the next row of the weights file.
+	// A nil row with nil error means a malformed row was skipped.
+	readWeightRow() (*weightRow, error)
+	resources() []string
+	// failEpoch reports an invalid epoch of the last read row.
+	failEpoch(err error) error
+	// takeErrors returns the collected errors and forgets them.
+	takeErrors() error
+	close() error
+}
+
+// epochHeader is implemented by weight sources which list their epochs
+// up front, so epochs without rows before the first row and after the last one are kept.
+type epochHeader interface {
+	headerRange() EpochRange
+}
+
+func openWeightSource(path string, opts Options) (weightSource, error) {
+	if opts.WideWeights {
+		return newWideSource(path, opts)
+	}
+	if FormatOf(path, opts) == FormatJson {
+		return newJsonLinesSource(path, opts)
+	}
+	return newCsvSource(path, opts, WeightsFields, opts.WeightsColumns)
+}
+
+func (src *csvSource) readWeightRow() (*weightRow, error) {
+	var resources = src.resources()
+	record, err := src.read(2 + len(resources))
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	var nodeName = record[0]
+	if nodeName == "" {
+		return nil, src.fail(1, nodeName, ErrEmptyName)
+	}
+	var epoch, ok = parseEpoch(record[1], src.opts)
+	if !ok {
+		return nil, src.fail(2, record[1], ErrBadEpoch)
+	}
+	var weight, errWeight = strconv.ParseInt(record[2], 10, 64)
+	if errWeight != nil {
+		return nil, src.fail(3, record[2], ErrBadWeight)
+	}
+
+	var row = &weightRow{node: nodeName, epoch: epoch, weight: weight}
+	if len(resources) > 1 {
+		row.extra = make([]int64, len(resources)-1)
+		for idx := range row.extra {
+			if row.extra[idx], errWeight = strconv.ParseInt(record[3+idx], 10, 64); errWeight != nil {
+				return nil, src.fail(4+idx, record[3+idx], ErrBadWeight)
+			}
+		}
+	}
+
+	return row, nil
+}
+
+func (src *csvSource) failEpoch(err error) error {
+	return src.fail(2, src.record[1], err)
+}
+
+func parseEpoch(value string, opts Options) (int, bool) {
+	if !opts.Timestamps {
+		var epoch, err = strconv.Atoi(value)
+		return epoch, err == nil && epoch >= 0
+	}
+
+	var t, err = parseTimestamp(value)
+	if err != nil {
+		return 0, false
+	}
+	return bucketOf(t, opts), true
+}
+
+// Stdin is the file name which makes readers use the standard input.
+const Stdin = "-"
+
+// files with this extension are decompressed while reading
+const gzipExt = ".gz"
+
+// source is an opened input file which keeps track of
+// the position of the last read row for error reporting.
+type source struct {
+	name string
+	file io.Closer // nil for the standard input
+	gz   io.Closer // decompressor of a .gz file, closed before the file
+	line int
+	opts Options
+	errs ErrorList
+}
+
+func openSource(path string, opts Options) (*source, io.Reader, error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var src = &source{name: path, opts: opts}
+	if path == Stdin {
+		src.name = "<stdin>"
+		return src, os.Stdin, nil
+	}
+
+	var file, err = os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	src.file = file
+
+	if strings.HasSuffix(strings.ToLower(path), gzipExt) {
+		var gz, err = gzip.NewReader(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		src.gz = gz
+		return src, gz, nil
+	}
+
+	return src, file, nil
+}
+
+func (src *source) report(err *ParseError) error {
+	if !src.opts.CollectAll {
+		return err
+	}
+
+	src.errs = append(src.errs, err)
+	return nil
+}
+
+func (src *source) takeErrors() error {
+	var err = src.errs.Err()
+	src.errs = nil
+
+	return err
+}
+
+func (src *source) close() error {
+	if src.file == nil {
+		return nil
+	}
+	var err error
+	if src.gz != nil {
+		err = src.gz.Close()
+	}
+	if fileErr := src.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// csvSource wraps csv.Reader. Rows are returned with fields
+// in their default order whatever the order of columns is.
+type csvSource struct {
+	*source
+	r       *csv.Reader
+	header  []string // names of fields in their default order
+	columns []int    // column index of every field, nil if columns are not mapped
+	record  []string // last read row
+
+	// without a header the first row is read ahead to count columns of resources
+	first    []string
+	firstErr error
+	peeked   bool
+	names    []string // names of weight columns, see resources
+}
+
+// newCsvSource opens a csv file whose rows consist of fields,
+// columns maps field names to columns of the file.
+func newCsvSource(filepath string, opts Options, fields []string, columns map[string]string) (*csvSource, error) {
+	var base, input, err = openSource(filepath, opts)
 	if err != nil {
-		log.Fatalln("Couldn't open the csv file", err)
+		return nil, err
+	}
+
+	var src = &csvSource{source: base}
+	src.r = csv.NewReader(input)
+	src.r.FieldsPerRecord = -1 // row width is checked by read
+	if opts.Delimiter != 0 {
+		src.r.Comma = opts.Delimiter
+	}
+	if !opts.NoHeader {
+		// columns names, an empty file has neither a header nor rows
+		if src.header, err = src.r.Read(); err != nil && err != io.EOF {
+			_ = src.close()
+			return nil, src.parseError(err)
+		}
+	}
+
+	if len(columns) > 0 {
+		if src.columns, err = mapColumns(fields, columns, src.header); err != nil {
+			_ = src.close()
+			return nil, &ParseError{File: src.name, Line: 1, Err: err}
+		}
+		src.header = src.project(src.header)
+	}
+
+	return src, nil
+}
+
+// return names of weight columns. Without a header the weight column is named "weight"
+// and columns of additional resources are named by their 1-based numbers.
+func (src *csvSource) resources() []string {
+	if src.opts.NoHeader && src.opts.MultiResource {
+		if src.names == nil {
+			src.names = src.numberedResources()
+		}
+		return src.names
+	}
+	if len(src.header) < 3 {
+		return []string{"weight"}
+	}
+	if !src.opts.MultiResource {
+		return src.header[2:3]
+	}
+	return src.header[2:]
+}
+
+// numberedResources reads the first row ahead and names the weight column and
+// all columns after it, columns after the mapped ones are appended as mapColumns does.
+func (src *csvSource) numberedResources() []string {
+	src.first, src.firstErr = src.r.Read()
+	src.peeked = true
+
+	var weight = 2
+	if src.columns != nil {
+		weight = src.columns[2]
+		var last = -1
+		for _, column := range src.columns {
+			if column > last {
+				last = column
+			}
+		}
+		for column := last + 1; column < len(src.first); column++ {
+			src.columns = append(src.columns, column)
+		}
+	}
+
+	var names = []string{"weight"}
+	for column := weight + 1; column < len(src.first); column++ {
+		names = append(names, strconv.Itoa(column+1))
+	}
+	return names
+}
+
+// read returns the next row having at least minFields fields.
+// A nil record with nil error means a malformed row was skipped.
+func (src *csvSource) read(minFields int) ([]string, error) {
+	var record []string
+	var err error
+	if src.peeked {
+		record, err = src.first, src.firstErr
+		src.first, src.firstErr, src.peeked = nil, nil, false
+	} else {
+		record, err = src.r.Read()
+	}
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, src.report(src.parseError(err))
+	}
+
+	src.line, _ = src.r.FieldPos(0)
+	src.record = src.project(record)
+	if len(src.record) < minFields {
+		return nil, src.fail(len(src.record)+1, "", ErrMissingField)
+	}
+
+	return src.record, nil
+}
+
+// parseError converts an error of csv.Reader
+func (src *csvSource) parseError(err error) *ParseError {
+	var parseErr = &ParseError{File: src.name, Err: err}
+	if csvErr, ok := err.(*csv.ParseError); ok {
+		parseErr.Line, parseErr.Column, parseErr.Err = csvErr.Line, 0, csvErr.Err
+	}
+	return parseErr
+}
+
+// fail reports an invalid field of the last read row.
+// It returns nil if errors are being collected.
+func (src *csvSource) fail(field int, value string, err error) error {
+	var column = field
+	if field > 0 && field <= len(src.columns) {
+		column = src.columns[field-1] + 1
 	}
-	r := csv.NewReader(csvFile)
-	_, _ = r.Read() // skip columns names
 
-	return r
+	return src.report(&ParseError{File: src.name, Line: src.line, Column: column, Value: value, Err: err})
 }
@@ -0,0 +1,53 @@
+package hierarchy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderError(t *testing.T) {
+	var path = writeTestFile(t, "weights.csv", "node,\"epoch,weight\n")
+	var _, err = ReadWeights(path, Options{})
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 1 {
+		t.Errorf("error %v, want a parse error of the header", err)
+	}
+}
+
+func TestNoHeaderResources(t *testing.T) {
+	var path = writeTestFile(t, "weights.csv", "a,0,1,10,100\nb,0,2,20,200\n")
+	for _, columns := range []map[string]string{nil, {"node": "1", "epoch": "2", "weight": "3"}} {
+		var h, err = ReadWeights(path, Options{NoHeader: true, MultiResource: true, WeightsColumns: columns})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(h.Resources, []string{"weight", "4", "5"}) {
+			t.Errorf("resources %v", h.Resources)
+		}
+		if h.WeightsPerEpoch[0]["b"] != 2 || !reflect.DeepEqual(h.ExtraWeightsPerEpoch[0]["b"], []int64{20, 200}) {
+			t.Errorf("weights %v %v", h.WeightsPerEpoch, h.ExtraWeightsPerEpoch)
+		}
+	}
+}
+
+func TestSharedColumn(t *testing.T) {
+	var path = writeTestFile(t, "weights.csv", "id,requests,day\na,10,0\n")
+	for _, columns := range []map[string]string{
+		{"weight": "requests"},       // epoch falls back to the second column
+		{"node": "1", "epoch": "id"}, // both map to the first column
+	} {
+		var _, err = ReadWeights(path, Options{WeightsColumns: columns})
+		if !errors.Is(err, ErrDuplicateColumn) {
+			t.Errorf("columns %v: error %v, want %v", columns, err, ErrDuplicateColumn)
+		}
+	}
+
+	var h, err = ReadWeights(path, Options{WeightsColumns: map[string]string{"epoch": "day", "weight": "requests"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.WeightsPerEpoch[0]["a"] != 10 {
+		t.Errorf("weights %v", h.WeightsPerEpoch)
+	}
+}
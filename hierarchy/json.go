@@ -0,0 +1,303 @@
+package hierarchy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+//---------------------------JSON tree----------------------
+
+// jsonNode is a node of a nested JSON tree: {"name": "root", "children": [...]}.
+type jsonNode struct {
+	Name     string      `json:"name"`
+	Children []*jsonNode `json:"children,omitempty"`
+}
+
+// readJsonTree reads a nested JSON tree. The file holds either one root object
+// or an array of them. Errors of the tree structure have no line number,
+// the path of the node is reported instead.
+func readJsonTree(jsonTree string, opts Options) (map[string]string, error) {
+	var src, input, err = openSource(jsonTree, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*jsonNode
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &roots)
+	} else {
+		var root jsonNode
+		err = json.Unmarshal(data, &root)
+		roots = append(roots, &root)
+	}
+	if err != nil {
+		return nil, &ParseError{File: src.name, Line: lineOfOffset(data, err), Err: err}
+	}
+
+	var childToParent = make(map[string]string)
+	if err = src.addJsonNodes(roots, childToParent); err != nil {
+		return nil, err
+	}
+
+	if err = src.takeErrors(); err != nil {
+		return nil, err
+	}
+	return childToParent, nil
+}
+
+// addJsonNodes adds the trees to the map in pre-order. Nodes wait on an explicit
+// stack, so deeply nested trees don't grow the goroutine stack.
+func (src *source) addJsonNodes(roots []*jsonNode, childToParent map[string]string) error {
+	type item struct {
+		node   *jsonNode
+		parent string // the node itself for a root
+		path   string // path of the parent, the position is added for a null node
+		idx    int    // position among the children of the parent or among the roots
+	}
+	var stack = make([]item, 0, len(roots))
+	for idx := len(roots) - 1; idx >= 0; idx-- {
+		stack = append(stack, item{roots[idx], "", "", idx})
+	}
+
+	for len(stack) > 0 {
+		var top = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if top.node == nil {
+			var path = top.path + "/children[" + strconv.Itoa(top.idx) + "]"
+			if top.path == "" {
+				path = "[" + strconv.Itoa(top.idx) + "]"
+			}
+			if err := src.report(&ParseError{File: src.name, Value: path, Err: ErrNullNode}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var node = top.node
+		var path = top.path + "/" + node.Name
+		if node.Name == "" {
+			if err := src.report(&ParseError{File: src.name, Value: path, Err: ErrEmptyName}); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := childToParent[node.Name]; ok {
+			if err := src.report(&ParseError{File: src.name, Value: path, Err: ErrDuplicateName}); err != nil {
+				return err
+			}
+			continue
+		}
+		if top.path == "" {
+			childToParent[node.Name] = node.Name
+		} else {
+			childToParent[node.Name] = top.parent
+		}
+
+		for idx := len(node.Children) - 1; idx >= 0; idx-- {
+			stack = append(stack, item{node.Children[idx], node.Name, path, idx})
+		}
+	}
+
+	return nil
+}
+
+// lineOfOffset returns the line of a syntax or type error, 0 if it is unknown.
+func lineOfOffset(data []byte, err error) int {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// WriteJsonTree writes the tree as nested JSON objects: one root object,
+// or an array if there are several roots. Children are sorted by name.
+func (h *Hierarchy) WriteJsonTree(w io.Writer) error {
+	var nodes = make(map[string]*jsonNode)
+	var nodeOf = func(name string) *jsonNode {
+		if _, ok := nodes[name]; !ok {
+			nodes[name] = &jsonNode{Name: name}
+		}
+		return nodes[name]
+	}
+
+	var children = make([]string, 0, len(h.ChildToParent))
+	for child := range h.ChildToParent {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+
+	var roots []*jsonNode
+	for _, child := range children {
+		var parent = h.ChildToParent[child]
+		if parent == child {
+			roots = append(roots, nodeOf(child))
+			continue
+		}
+		nodeOf(parent).Children = append(nodeOf(parent).Children, nodeOf(child))
+	}
+	for _, parent := range h.parentsWithoutRow() { // written as roots not to lose them
+		roots = append(roots, nodeOf(parent))
+	}
+
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if len(roots) == 1 {
+		return encoder.Encode(roots[0])
+	}
+	return encoder.Encode(roots)
+}
+
+// return sorted names of parents which have no row of their own
+func (h *Hierarchy) parentsWithoutRow() []string {
+	var parents []string
+	for _, parent := range h.ChildToParent {
+		if _, ok := h.ChildToParent[parent]; !ok {
+			parents = append(parents, parent)
+		}
+	}
+	sort.Strings(parents)
+
+	var unique = parents[:0]
+	for idx, parent := range parents {
+		if idx == 0 || parent != parents[idx-1] {
+			unique = append(unique, parent)
+		}
+	}
+	return unique
+}
+
+//---------------------------JSON Lines weights----------------------
+
+// jsonWeightRow is one line of a JSON Lines weights file:
+// {"node": "a", "epoch": 3, "weight": 10}. The epoch may be a string
+// holding a timestamp when Options.Timestamps is set.
+type jsonWeightRow struct {
+	Node   *string         `json:"node"`
+	Epoch  json.RawMessage `json:"epoch"`
+	Weight json.RawMessage `json:"weight"`
+}
+
+// jsonLinesSource reads a JSON Lines weights file.
+// It holds a single resource, Options.MultiResource is ignored.
+type jsonLinesSource struct {
+	*source
+	r     *bufio.Reader
+	epoch string // epoch of the last read row
+}
+
+func newJsonLinesSource(jsonWeights string, opts Options) (*jsonLinesSource, error) {
+	var base, input, err = openSource(jsonWeights, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonLinesSource{source: base, r: bufio.NewReader(input)}, nil
+}
+
+func (src *jsonLinesSource) resources() []string {
+	return []string{"weight"}
+}
+
+func (src *jsonLinesSource) readWeightRow() (*weightRow, error) {
+	var line, err = src.r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	src.line++
+	if line = bytes.TrimSpace(line); len(line) == 0 {
+		return nil, nil
+	}
+
+	var record jsonWeightRow
+	if err = json.Unmarshal(line, &record); err != nil {
+		return nil, src.fail("", err)
+	}
+
+	src.epoch = string(record.Epoch)
+	if record.Node == nil {
+		return nil, src.fail("node", ErrMissingField)
+	}
+	if *record.Node == "" {
+		return nil, src.fail("", ErrEmptyName)
+	}
+	if record.Epoch == nil {
+		return nil, src.fail("epoch", ErrMissingField)
+	}
+	if record.Weight == nil {
+		return nil, src.fail("weight", ErrMissingField)
+	}
+
+	var epochValue = string(record.Epoch)
+	if unquoted, err := strconv.Unquote(epochValue); err == nil {
+		epochValue = unquoted
+	}
+	var epoch, ok = parseEpoch(epochValue, src.opts)
+	if !ok {
+		return nil, src.fail(src.epoch, ErrBadEpoch)
+	}
+	var weight, errWeight = strconv.ParseInt(string(record.Weight), 10, 64)
+	if errWeight != nil {
+		return nil, src.fail(string(record.Weight), ErrBadWeight)
+	}
+
+	return &weightRow{node: *record.Node, epoch: epoch, weight: weight}, nil
+}
+
+// fail reports the last read line, JSON has no columns.
+func (src *jsonLinesSource) fail(value string, err error) error {
+	return src.report(&ParseError{File: src.name, Line: src.line, Value: value, Err: err})
+}
+
+func (src *jsonLinesSource) failEpoch(err error) error {
+	return src.fail(src.epoch, err)
+}
+
+// WriteJsonLinesWeights writes one line per row sorted by epoch and node name.
+// Hierarchies with several resources can't be written.
+func (h *Hierarchy) WriteJsonLinesWeights(w io.Writer) error {
+	if len(h.Resources) > 1 {
+		return errors.New("hierarchy : JSON Lines weights hold a single resource")
+	}
+
+	var encoder = json.NewEncoder(w)
+	for idx, weights := range h.WeightsPerEpoch {
+		var nodes = make([]string, 0, len(weights))
+		for node := range weights {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+
+		var epoch = json.RawMessage(strconv.Itoa(h.Range.First + idx))
+		for _, node := range nodes {
+			var name = node
+			var weight = json.RawMessage(strconv.FormatInt(weights[node], 10))
+			if err := encoder.Encode(jsonWeightRow{Node: &name, Epoch: epoch, Weight: weight}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package hierarchy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestJsonTreeNullNode(t *testing.T) {
+	for content, path := range map[string]string{
+		`{"name":"r","children":[{"name":"a"},null]}`: "/r/children[1]",
+		`[{"name":"r"},null]`:                         "[1]",
+	} {
+		var _, err = ReadTreeNodes(writeTestFile(t, "tree.json", content), Options{})
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, ErrNullNode) || parseErr.Value != path {
+			t.Errorf("%s: error %v, want a null node at %s", content, err, path)
+		}
+	}
+}
+
+func TestJsonTreeDeepChain(t *testing.T) {
+	const depth = 5000 // encoding/json itself limits nesting to 10000 levels
+	var b strings.Builder
+	for idx := 0; idx < depth; idx++ {
+		b.WriteString(`{"name":"n` + strconv.Itoa(idx) + `","children":[`)
+	}
+	b.WriteString(strings.Repeat("]}", depth))
+
+	var childToParent, err = ReadTreeNodes(writeTestFile(t, "tree.json", b.String()), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(childToParent) != depth || childToParent["n0"] != "n0" || childToParent["n4999"] != "n4998" {
+		t.Errorf("%d nodes, n0 -> %s, n4999 -> %s", len(childToParent), childToParent["n0"], childToParent["n4999"])
+	}
+}
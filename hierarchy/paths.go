@@ -0,0 +1,160 @@
+package hierarchy
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathRoot is the root node of hierarchies built from paths.
+// Other nodes are named by their slash-separated path without the leading slash.
+const PathRoot = "/"
+
+// PathNode returns the node name of a slash-separated path, e.g. "org/team/project".
+// Empty components, "." and ".." are resolved as by path.Clean.
+func PathNode(p string) string {
+	var name = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return PathRoot
+	}
+	return name
+}
+
+// AddPath adds the node of the path and all its ancestors up to PathRoot
+// to childToParent and returns the node name.
+func AddPath(childToParent map[string]string, p string) string {
+	var name = PathNode(p)
+	for child := name; ; {
+		if _, ok := childToParent[child]; ok {
+			break
+		}
+		if child == PathRoot {
+			childToParent[child] = child
+			break
+		}
+
+		var parent = PathNode(path.Dir(child))
+		childToParent[child] = parent
+		child = parent
+	}
+
+	return name
+}
+
+// ReadPaths reads a weights file whose node column holds slash-separated paths,
+// e.g. "org/team/project,0,10". The tree is made of the paths and their prefixes.
+func ReadPaths(pathWeights string, opts Options) (*Hierarchy, error) {
+	var h, err = ReadWeights(pathWeights, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h.ChildToParent = make(map[string]string)
+	AddPath(h.ChildToParent, PathRoot)
+	for idx, weights := range h.WeightsPerEpoch {
+		var nodeWeights = make(map[string]int64, len(weights))
+		for p, weight := range weights {
+			nodeWeights[AddPath(h.ChildToParent, p)] += weight
+		}
+		h.WeightsPerEpoch[idx] = nodeWeights
+	}
+
+	for idx, extra := range h.ExtraWeightsPerEpoch {
+		var nodeExtra = make(map[string][]int64, len(extra))
+		for p, weights := range extra {
+			var name = PathNode(p)
+			if nodeExtra[name] == nil {
+				nodeExtra[name] = make([]int64, len(weights))
+			}
+			for r, weight := range weights {
+				nodeExtra[name][r] += weight
+			}
+		}
+		h.ExtraWeightsPerEpoch[idx] = nodeExtra
+	}
+
+	return h, nil
+}
+
+type DirOptions struct {
+	Files    bool  // files are leaves of their directories, otherwise file sizes are weights of directories
+	MaxDepth int   // deeper entries are counted in their ancestor at this depth, 0 is unlimited
+	Unit     int64 // size of every file is divided by Unit rounding up, bytes if 0
+	Hidden   bool  // include files and directories whose names start with a dot
+}
+
+// ImportDirectory builds a hierarchy of a local directory with one epoch
+// where node weights are sizes of regular files. Symbolic links are not followed.
+func ImportDirectory(dir string, opts DirOptions) (*Hierarchy, error) {
+	var h = &Hierarchy{
+		ChildToParent:   make(map[string]string),
+		WeightsPerEpoch: []map[string]int64{make(map[string]int64)},
+		Range:           EpochRange{First: 0, Last: 0},
+		Resources:       []string{"size"},
+	}
+	var weights = h.WeightsPerEpoch[0]
+	AddPath(h.ChildToParent, PathRoot)
+
+	var err = filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		var name = PathNode(filepath.ToSlash(rel))
+
+		if name != PathRoot && !opts.Hidden && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			AddPath(h.ChildToParent, truncatePath(name, opts.MaxDepth))
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !opts.Files {
+			name = PathNode(path.Dir(name))
+		}
+		name = AddPath(h.ChildToParent, truncatePath(name, opts.MaxDepth))
+		weights[name] += sizeInUnits(info.Size(), opts.Unit)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// return the ancestor of the node at the depth, the node itself if it is not deeper
+func truncatePath(name string, depth int) string {
+	if depth <= 0 || name == PathRoot {
+		return name
+	}
+
+	var components = strings.Split(name, "/")
+	if len(components) <= depth {
+		return name
+	}
+	return strings.Join(components[:depth], "/")
+}
+
+func sizeInUnits(size int64, unit int64) int64 {
+	if unit <= 1 {
+		return size
+	}
+	return (size + unit - 1) / unit
+}
@@ -0,0 +1,132 @@
+package hierarchy
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+type SliceOptions struct {
+	Root   string      // keep only the subtree of this node, empty keeps the whole tree
+	Epochs *EpochRange // keep only epochs from First to Last, nil keeps all epochs
+	Leaves int         // keep a random sample of leaves with their ancestors, 0 keeps all leaves
+	Seed   int64       // seed of the leaves sample
+}
+
+// Slice returns a smaller hierarchy which can be written back by WriteCsv.
+// Weights of the nodes which are cut off are dropped.
+func (h *Hierarchy) Slice(opts SliceOptions) (*Hierarchy, error) {
+	var children = make(map[string][]string)
+	var roots []string
+	for child, parent := range h.ChildToParent {
+		if child == parent {
+			roots = append(roots, child)
+		} else {
+			children[parent] = append(children[parent], child)
+		}
+	}
+	for _, names := range children {
+		sort.Strings(names) // the sample must not depend on map order
+	}
+
+	var root = opts.Root
+	if root == "" {
+		if len(roots) != 1 {
+			return nil, errors.New("hierarchy : slice needs a tree with exactly one root")
+		}
+		root = roots[0]
+	}
+	if _, ok := h.ChildToParent[root]; !ok {
+		if _, ok = children[root]; !ok {
+			return nil, errors.New("hierarchy : unknown slice root " + root)
+		}
+	}
+
+	var kept = make(map[string]bool)
+	var leaves []string
+	var stack = []string{root}
+	for len(stack) > 0 {
+		var name = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if kept[name] { // cycle
+			continue
+		}
+
+		kept[name] = true
+		if len(children[name]) == 0 {
+			leaves = append(leaves, name)
+		}
+		stack = append(stack, children[name]...)
+	}
+
+	var childToParent = make(map[string]string)
+	if opts.Leaves > 0 && opts.Leaves < len(leaves) {
+		sort.Strings(leaves)
+		var r = rand.New(rand.NewSource(opts.Seed))
+		for _, idx := range r.Perm(len(leaves))[:opts.Leaves] {
+			for name := leaves[idx]; name != root; name = h.ChildToParent[name] {
+				if _, ok := childToParent[name]; ok {
+					break
+				}
+				childToParent[name] = h.ChildToParent[name]
+			}
+		}
+	} else {
+		for name := range kept {
+			childToParent[name] = h.ChildToParent[name]
+		}
+	}
+	childToParent[root] = root
+
+	return h.sliceWeights(childToParent, opts.Epochs), nil
+}
+
+func (h *Hierarchy) sliceWeights(childToParent map[string]string, epochs *EpochRange) *Hierarchy {
+	var sliced = &Hierarchy{ChildToParent: childToParent, Range: h.Range, Resources: h.Resources}
+
+	var first, last = 0, len(h.WeightsPerEpoch) - 1
+	if epochs != nil { // clamp to the epochs of h before subtracting, bounds may be math.MinInt or math.MaxInt
+		first = maxInt(first, maxInt(epochs.First, h.Range.First)-h.Range.First)
+		last = minInt(last, minInt(epochs.Last, h.Range.First+last)-h.Range.First)
+	}
+	if first > last {
+		first, last = 0, -1
+	}
+	sliced.Range.First, sliced.Range.Last = h.Range.First+first, h.Range.First+last
+
+	for idx := first; idx <= last; idx++ {
+		var weights = make(map[string]int64)
+		for name, weight := range h.WeightsPerEpoch[idx] {
+			if _, ok := childToParent[name]; ok {
+				weights[name] = weight
+			}
+		}
+		sliced.WeightsPerEpoch = append(sliced.WeightsPerEpoch, weights)
+
+		if h.ExtraWeightsPerEpoch != nil {
+			var extra = make(map[string][]int64)
+			for name, weight := range h.ExtraWeightsPerEpoch[idx] {
+				if _, ok := childToParent[name]; ok {
+					extra[name] = weight
+				}
+			}
+			sliced.ExtraWeightsPerEpoch = append(sliced.ExtraWeightsPerEpoch, extra)
+		}
+	}
+
+	return sliced
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,34 @@
+package hierarchy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSliceEpochsWithNegativeFirst(t *testing.T) {
+	var h = &Hierarchy{
+		ChildToParent:   map[string]string{"r": "r", "a": "r"},
+		WeightsPerEpoch: []map[string]int64{{"a": 1}, {"a": 2}, {"a": 3}},
+		Range:           EpochRange{First: -2, Last: 0},
+	}
+
+	for _, epochs := range []EpochRange{
+		{First: math.MinInt, Last: math.MaxInt},
+		{First: -1, Last: math.MaxInt},
+		{First: math.MinInt, Last: -1},
+	} {
+		var sliced, err = h.Slice(SliceOptions{Epochs: &epochs})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var first, last = maxInt(epochs.First, h.Range.First), minInt(epochs.Last, h.Range.Last)
+		if sliced.Range.First != first || sliced.Range.Last != last || len(sliced.WeightsPerEpoch) != last-first+1 {
+			t.Errorf("epochs %v: range %v with %d epochs", epochs, sliced.Range, len(sliced.WeightsPerEpoch))
+			continue
+		}
+		if sliced.WeightsPerEpoch[0]["a"] != int64(first+3) {
+			t.Errorf("epochs %v: first weights %v", epochs, sliced.WeightsPerEpoch[0])
+		}
+	}
+}
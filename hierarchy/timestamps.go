@@ -0,0 +1,109 @@
+package hierarchy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EpochRange describes which epochs are covered by loaded weights.
+// Epoch numbers are taken from the input file, or are bucket indexes
+// counted from Options.EpochOrigin when timestamps are used.
+type EpochRange struct {
+	First int // epoch number of WeightsPerEpoch[0]
+	Last  int // epoch number of the last element, First-1 if there are no epochs
+
+	Origin   time.Time     // start of the bucket 0, zero unless timestamps are used
+	Duration time.Duration // zero unless timestamps are used
+}
+
+func newEpochRange(opts Options) EpochRange {
+	var epochRange = EpochRange{First: 0, Last: -1}
+	if opts.Timestamps {
+		epochRange.Origin = originOf(opts)
+		epochRange.Duration = opts.EpochDuration
+	}
+
+	return epochRange
+}
+
+func (r *EpochRange) add(epoch int) {
+	if r.Len() == 0 {
+		r.First, r.Last = epoch, epoch
+		return
+	}
+
+	if epoch < r.First {
+		r.First = epoch
+	}
+	if epoch > r.Last {
+		r.Last = epoch
+	}
+}
+
+func (r EpochRange) Len() int {
+	return r.Last - r.First + 1
+}
+
+// return start time of the epoch, zero time if timestamps are not used
+func (r EpochRange) Start(epoch int) time.Time {
+	if r.Duration == 0 {
+		return time.Time{}
+	}
+	return r.Origin.Add(time.Duration(epoch) * r.Duration)
+}
+
+func (r EpochRange) String() string {
+	if r.Len() == 0 {
+		return "no epochs"
+	}
+	if r.Duration == 0 {
+		return fmt.Sprintf("epochs %d..%d (%d epochs)", r.First, r.Last, r.Len())
+	}
+
+	return fmt.Sprintf("%s..%s (%d epochs of %v)", r.Start(r.First).Format(time.RFC3339),
+		r.Start(r.Last+1).Format(time.RFC3339), r.Len(), r.Duration)
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseTimestamp accepts Unix seconds or one of timestampLayouts.
+func parseTimestamp(value string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+func originOf(opts Options) time.Time {
+	if opts.EpochOrigin.IsZero() {
+		return time.Unix(0, 0).UTC()
+	}
+	return opts.EpochOrigin
+}
+
+// return index of the bucket containing t, buckets before origin are negative
+func bucketOf(t time.Time, opts Options) int {
+	var origin = originOf(opts)
+
+	var bucket = t.Sub(origin) / opts.EpochDuration
+	if t.Before(origin.Add(bucket * opts.EpochDuration)) {
+		bucket--
+	}
+
+	return int(bucket)
+}
@@ -0,0 +1,130 @@
+package hierarchy
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+var ErrBadEvent = errors.New("unknown topology event")
+
+type EventKind int
+
+const (
+	AddNode    EventKind = iota // Node appears under Parent
+	DeleteNode                  // Node disappears, its children are moved to its parent
+	MoveNode                    // Node with its subtree is moved under Parent
+)
+
+var eventKindNames = map[string]EventKind{"add": AddNode, "delete": DeleteNode, "move": MoveNode}
+
+func (kind EventKind) String() string {
+	for name, k := range eventKindNames {
+		if k == kind {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// TopologyEvent is a change of the tree which takes effect
+// before the weights of Epoch are applied.
+type TopologyEvent struct {
+	Epoch  int
+	Kind   EventKind
+	Node   string
+	Parent string // empty for DeleteNode
+}
+
+// EventQueue returns topology events in the order of epochs.
+type EventQueue struct {
+	events []TopologyEvent
+	next   int
+}
+
+// NewEventQueue sorts events by epoch, events of the same epoch keep their order.
+func NewEventQueue(events []TopologyEvent) *EventQueue {
+	var sorted = make([]TopologyEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Epoch < sorted[j].Epoch
+	})
+
+	return &EventQueue{events: sorted}
+}
+
+// PopUntil returns all not yet returned events with Epoch <= epoch.
+// It is safe to call on nil queue.
+func (q *EventQueue) PopUntil(epoch int) []TopologyEvent {
+	if q == nil {
+		return nil
+	}
+
+	var start = q.next
+	for q.next < len(q.events) && q.events[q.next].Epoch <= epoch {
+		q.next++
+	}
+
+	return q.events[start:q.next]
+}
+
+// ReadTopologyEvents reads a csv file with columns epoch,event,node,parent
+// where event is one of add, delete or move.
+func ReadTopologyEvents(csvEvents string, opts Options) (*EventQueue, error) {
+	var src, err = newCsvSource(csvEvents, opts, EventsFields, opts.EventsColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+
+	var events = make([]TopologyEvent, 0)
+	for {
+		record, err := src.read(3)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			continue
+		}
+
+		var event TopologyEvent
+		var ok bool
+		if event.Epoch, ok = parseEpoch(record[0], src.opts); !ok {
+			if err = src.fail(1, record[0], ErrBadEpoch); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if event.Kind, ok = eventKindNames[record[1]]; !ok {
+			if err = src.fail(2, record[1], ErrBadEvent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if event.Node = record[2]; event.Node == "" {
+			if err = src.fail(3, event.Node, ErrEmptyName); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if event.Kind != DeleteNode {
+			if len(record) < 4 || record[3] == "" {
+				if err = src.fail(4, "", ErrEmptyName); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			event.Parent = record[3]
+		}
+
+		events = append(events, event)
+	}
+
+	if err = src.errs.Err(); err != nil {
+		return nil, err
+	}
+	return NewEventQueue(events), nil
+}
@@ -0,0 +1,158 @@
+package hierarchy
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// wideSource reads a weights matrix with one row per node and one column per epoch:
+//
+//	node,0,1,2
+//	a,10,,5
+//
+// The header holds epoch numbers, or timestamps when Options.Timestamps is set.
+// Empty cells mean no weight. The whole matrix is read at once and its rows
+// are returned ordered by epoch, so it can be streamed as a long weights file.
+type wideSource struct {
+	*csvSource
+	rows       []*weightRow
+	next       int
+	epochRange EpochRange // epochs of the header
+}
+
+func newWideSource(csvWeights string, opts Options) (*wideSource, error) {
+	if opts.NoHeader {
+		return nil, errors.New("hierarchy : wide weights need a header with epochs")
+	}
+	var src, err = newCsvSource(csvWeights, opts, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var wide = &wideSource{csvSource: src, epochRange: newEpochRange(opts)}
+	if err = wide.readAll(); err != nil {
+		_ = src.close()
+		return nil, err
+	}
+
+	return wide, nil
+}
+
+func (src *wideSource) readAll() error {
+	var epochs = make([]int, len(src.header))
+	var valid = make([]bool, len(src.header))
+	src.line = 1
+	for column := 1; column < len(src.header); column++ {
+		if epochs[column], valid[column] = parseEpoch(src.header[column], src.opts); !valid[column] {
+			if err := src.fail(column+1, src.header[column], ErrBadEpoch); err != nil {
+				return err
+			}
+			continue
+		}
+		src.epochRange.add(epochs[column])
+	}
+
+	for {
+		record, err := src.read(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			continue
+		}
+		if record[0] == "" {
+			if err = src.fail(1, record[0], ErrEmptyName); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for column := 1; column < len(record) && column < len(src.header); column++ {
+			if !valid[column] || record[column] == "" {
+				continue
+			}
+			var weight, err = strconv.ParseInt(record[column], 10, 64)
+			if err != nil {
+				if err = src.fail(column+1, record[column], ErrBadWeight); err != nil {
+					return err
+				}
+				continue
+			}
+			src.rows = append(src.rows, &weightRow{node: record[0], epoch: epochs[column], weight: weight})
+		}
+	}
+
+	sort.SliceStable(src.rows, func(i, j int) bool {
+		return src.rows[i].epoch < src.rows[j].epoch
+	})
+
+	return nil
+}
+
+func (src *wideSource) resources() []string {
+	return []string{"weight"}
+}
+
+func (src *wideSource) readWeightRow() (*weightRow, error) {
+	if src.next >= len(src.rows) {
+		return nil, io.EOF
+	}
+	src.next++
+
+	return src.rows[src.next-1], nil
+}
+
+// epochs without weights at the edges of the header are kept
+func (src *wideSource) headerRange() EpochRange {
+	return src.epochRange
+}
+
+// rows are sorted, so epochs are never out of order
+func (src *wideSource) failEpoch(err error) error {
+	return src.report(&ParseError{File: src.name, Err: err})
+}
+
+// WriteWideWeights writes one row per node sorted by name and one column per epoch.
+// Cells of epochs without a weight of the node are left empty.
+func (h *Hierarchy) WriteWideWeights(w io.Writer) error {
+	if len(h.Resources) > 1 {
+		return errors.New("hierarchy : wide weights hold a single resource")
+	}
+
+	var header = []string{"node"}
+	var nodeSet = make(map[string]bool)
+	for idx, weights := range h.WeightsPerEpoch {
+		header = append(header, strconv.Itoa(h.Range.First+idx))
+		for node := range weights {
+			nodeSet[node] = true
+		}
+	}
+	var nodes = make([]string, 0, len(nodeSet))
+	for node := range nodeSet {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	var csvWriter = csv.NewWriter(w)
+	_ = csvWriter.Write(header)
+	for _, node := range nodes {
+		var record = []string{node}
+		for _, weights := range h.WeightsPerEpoch {
+			var cell string
+			if weight, ok := weights[node]; ok {
+				cell = strconv.FormatInt(weight, 10)
+			}
+			record = append(record, cell)
+		}
+		_ = csvWriter.Write(record)
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
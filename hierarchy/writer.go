@@ -0,0 +1,121 @@
+package hierarchy
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// WriteCsv writes the hierarchy in the format read by NewHierarchy.
+// Rows are sorted, so equal hierarchies are written to equal files.
+func (h *Hierarchy) WriteCsv(csvChildParent string, csvWeightsPerEpoch string) error {
+	if err := writeFile(csvChildParent, h.WriteTree); err != nil {
+		return err
+	}
+
+	return writeFile(csvWeightsPerEpoch, h.WriteWeights)
+}
+
+// Write writes the hierarchy in the given format,
+// an empty format is chosen by the extension of the tree file.
+func (h *Hierarchy) Write(treePath string, weightsPath string, format string) error {
+	if FormatOf(treePath, Options{Format: format}) == FormatJson {
+		if err := writeFile(treePath, h.WriteJsonTree); err != nil {
+			return err
+		}
+		return writeFile(weightsPath, h.WriteJsonLinesWeights)
+	}
+
+	return h.WriteCsv(treePath, weightsPath)
+}
+
+// WriteWeightsFile writes only the weights, as a matrix if wide is set,
+// otherwise in the long layout of the format chosen by the file extension.
+func (h *Hierarchy) WriteWeightsFile(weightsPath string, wide bool) error {
+	switch {
+	case wide:
+		return writeFile(weightsPath, h.WriteWideWeights)
+	case FormatOf(weightsPath, Options{}) == FormatJson:
+		return writeFile(weightsPath, h.WriteJsonLinesWeights)
+	}
+	return writeFile(weightsPath, h.WriteWeights)
+}
+
+func (h *Hierarchy) WriteTree(w io.Writer) error {
+	var csvWriter = csv.NewWriter(w)
+	_ = csvWriter.Write([]string{"child", "parent"})
+
+	var children = make([]string, 0, len(h.ChildToParent))
+	for child := range h.ChildToParent {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+
+	for _, child := range children {
+		_ = csvWriter.Write([]string{child, h.ChildToParent[child]})
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteWeights writes rows sorted by epoch and node name,
+// weights of additional resources are written to extra columns.
+func (h *Hierarchy) WriteWeights(w io.Writer) error {
+	var resources = h.Resources
+	if len(resources) == 0 {
+		resources = []string{"weight"}
+	}
+
+	var csvWriter = csv.NewWriter(w)
+	_ = csvWriter.Write(append([]string{"node", "epoch"}, resources...))
+
+	for idx, weights := range h.WeightsPerEpoch {
+		var nodes = make([]string, 0, len(weights))
+		for node := range weights {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+
+		var epoch = strconv.Itoa(h.Range.First + idx)
+		for _, node := range nodes {
+			var record = []string{node, epoch, strconv.FormatInt(weights[node], 10)}
+			if h.ExtraWeightsPerEpoch != nil {
+				var extra = h.ExtraWeightsPerEpoch[idx][node]
+				for r := 1; r < len(resources); r++ {
+					var weight int64
+					if r-1 < len(extra) {
+						weight = extra[r-1]
+					}
+					record = append(record, strconv.FormatInt(weight, 10))
+				}
+			}
+			_ = csvWriter.Write(record)
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func writeFile(filepath string, write func(w io.Writer) error) error {
+	var file, err = os.Create(filepath)
+	if err != nil {
+		return err
+	}
+
+	var w = bufio.NewWriter(file)
+	if err = write(w); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
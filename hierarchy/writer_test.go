@@ -0,0 +1,90 @@
+package hierarchy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// csvHierarchy reads a hierarchy with a nested tree and an epoch without rows
+func csvHierarchy(t *testing.T) *Hierarchy {
+	t.Helper()
+	var tree = writeTestFile(t, "ChildParent.csv", "child,parent\nroot,root\na,root\nb,root\nc,b\nd,c\n")
+	var weights = writeTestFile(t, "WeightsPerEpoch.csv",
+		"node,epoch,weight\nroot,1,5\na,1,10\nc,1,0\nd,2,7\nb,4,3\nd,4,1\n")
+	var h, err = NewHierarchy(tree, weights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestJsonRoundTrip(t *testing.T) {
+	var h = csvHierarchy(t)
+	var dir = t.TempDir()
+	var tree, weights = filepath.Join(dir, "ChildParent.json"), filepath.Join(dir, "WeightsPerEpoch.jsonl")
+	if err := h.Write(tree, weights, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var back, err = NewHierarchyWithOptions(tree, weights, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, h) {
+		t.Errorf("read back %+v, want %+v", back, h)
+	}
+}
+
+func TestWideRoundTrip(t *testing.T) {
+	var h = csvHierarchy(t)
+	var weights = filepath.Join(t.TempDir(), "wide.csv")
+	if err := h.WriteWeightsFile(weights, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var back, err = ReadWeights(weights, Options{WideWeights: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back.ChildToParent = h.ChildToParent
+	if !reflect.DeepEqual(back, h) {
+		t.Errorf("read back %+v, want %+v", back, h)
+	}
+}
+
+func TestWideRoundTripKeepsEmptyEdges(t *testing.T) {
+	var path = writeTestFile(t, "wide.csv", "node,0,1,2,3\na,,5,,\nb,,,2,\n")
+	var h, err = ReadWeights(path, Options{WideWeights: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Range.First != 0 || h.Range.Last != 3 || len(h.WeightsPerEpoch) != 4 {
+		t.Fatalf("range %v with %d epochs, expected epochs 0..3", h.Range, len(h.WeightsPerEpoch))
+	}
+
+	var weights = filepath.Join(t.TempDir(), "back.csv")
+	if err = h.WriteWeightsFile(weights, true); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadWeights(weights, Options{WideWeights: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, h) {
+		t.Errorf("read back %+v, want %+v", back, h)
+	}
+
+	src, err := OpenEpochSource(weights, Options{WideWeights: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	numbers, streamed, err := readAll(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(numbers, []int{0, 1, 2, 3}) || !reflect.DeepEqual(streamed, h.WeightsPerEpoch) {
+		t.Errorf("streamed epochs %v with weights %v, want %v", numbers, streamed, h.WeightsPerEpoch)
+	}
+}
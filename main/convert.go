@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+)
+
+// runConvert converts a weights file between the long and the wide layout:
+//
+//	main convert -weights=<file> -out=<file> [-weights_layout=long/wide -layout=wide/long]
+//
+// The layout of the output is the opposite of the input one by default.
+func runConvert(args []string) error {
+	var outFile string
+	var wide, hasLayout bool
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+		if ok, err := parseInputParameter(parameter, value, arg); ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch parameter {
+		case "out":
+			outFile = value
+		case "layout":
+			wide, err = parseLayout(value, arg)
+			hasLayout = true
+		default:
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if weightsFile == "" {
+		return errors.New("error: weights not specified")
+	}
+	if outFile == "" {
+		return errors.New("error: out not specified")
+	}
+	if !hasLayout {
+		wide = !hierarchyOptions.WideWeights
+	}
+
+	var h, err = hierarchy.ReadWeights(weightsFile, hierarchyOptions)
+	if err != nil {
+		return err
+	}
+
+	return h.WriteWeightsFile(outFile, wide)
+}
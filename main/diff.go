@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+	"github.com/dati-mipt/dhsbpp/packing"
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// runDiff prints changes between two tree files or between two epochs of a dataset:
+//
+//	main diff -old=<tree file> -new=<tree file> [-format=text/json]
+//	main diff -dataset=<dir> -old_epoch=N -new_epoch=N [-format=text/json]
+func runDiff(args []string) error {
+	var oldFile, newFile string
+	var oldEpoch, newEpoch int
+	var oldEpochSet, newEpochSet bool // epochs may be negative, so there is no value meaning "not set"
+	var format = "text"
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+		if parameter != "format" { // -format selects the output format here
+			if ok, err := parseInputParameter(parameter, value, arg); ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		switch parameter {
+		case "old":
+			oldFile = value
+		case "new":
+			newFile = value
+		case "old_epoch":
+			oldEpoch, err = strconv.Atoi(value)
+			oldEpochSet = true
+		case "new_epoch":
+			newEpoch, err = strconv.Atoi(value)
+			newEpochSet = true
+		case "format":
+			if value != "text" && value != "json" {
+				err = errors.New("unknown format")
+			}
+			format = value
+		default:
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+
+		if err != nil {
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+	}
+
+	var diff *tree.Diff
+	var err error
+	switch {
+	case oldFile != "" && newFile != "":
+		diff, err = diffTreeFiles(oldFile, newFile)
+	case oldEpochSet && newEpochSet:
+		diff, err = diffEpochs(oldEpoch, newEpoch)
+	default:
+		return errors.New("error: either old and new or old_epoch and new_epoch must be specified")
+	}
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return diff.WriteJSON(os.Stdout)
+	}
+	return diff.WriteText(os.Stdout)
+}
+
+func diffTreeFiles(oldFile string, newFile string) (*tree.Diff, error) {
+	var roots [2]*tree.Node
+	for idx, file := range []string{oldFile, newFile} {
+		var childToParent, err = hierarchy.ReadTreeNodes(file, hierarchyOptions)
+		if err != nil {
+			return nil, err
+		}
+		if roots[idx], err = newTree(childToParent); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	return tree.DiffTrees(roots[0], roots[1]), nil
+}
+
+// diffEpochs compares partition trees of the dataset after topology events of each epoch,
+// sizes of nodes are their weights in the epoch
+func diffEpochs(oldEpoch int, newEpoch int) (*tree.Diff, error) {
+	if err := resolveInputFiles(); err != nil {
+		return nil, err
+	}
+	var h, err = readHierarchy()
+	if err != nil {
+		return nil, err
+	}
+
+	var pRoots [2]*tree.PartitionNode
+	for idx, epoch := range []int{oldEpoch, newEpoch} {
+		if pRoots[idx], err = partitionTreeAt(h, epoch); err != nil {
+			return nil, err
+		}
+	}
+
+	return tree.DiffPartitionTrees(pRoots[0], pRoots[1]), nil
+}
+
+func partitionTreeAt(h *hierarchy.Hierarchy, epoch int) (*tree.PartitionNode, error) {
+	if epoch < h.Range.First || epoch > h.Range.Last {
+		return nil, fmt.Errorf("error: epoch %d is out of the dataset epochs %d..%d",
+			epoch, h.Range.First, h.Range.Last)
+	}
+
+	var root, err = newTree(h.ChildToParent)
+	if err != nil {
+		return nil, err
+	}
+	var pRoot = tree.NewPartitionTree(root)
+
+	if eventsFile != "" {
+		var events, err = hierarchy.ReadTopologyEvents(eventsFile, hierarchyOptions)
+		if err != nil {
+			return nil, err
+		}
+		nameToPartitionNode, _ := pRoot.MapNameToPartitionNode()
+		if err = packing.ApplyTopologyEvents(nil, events.PopUntil(epoch), nameToPartitionNode); err != nil {
+			return nil, err
+		}
+	}
+
+	var weights = []map[string]int64{h.WeightsPerEpoch[epoch-h.Range.First]}
+	if err = pRoot.SetInitialSize(weights, 1, nil); err != nil {
+		return nil, err
+	}
+	return pRoot, nil
+}
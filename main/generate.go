@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dati-mipt/dhsbpp/generator"
+)
+
+// runGenerate writes a synthetic dataset:
+//
+//	main generate -out=<dir> [-seed=N -shape=recursive -nodes=N -format=csv/json ...]
+func runGenerate(args []string) error {
+	var cfg = generator.DefaultConfig()
+	var outDir, format string
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+
+		switch parameter {
+		case "out":
+			outDir = value
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "shape":
+			cfg.Shape, err = generator.ParseTreeShape(value)
+		case "nodes":
+			cfg.Nodes, err = strconv.Atoi(value)
+		case "depth":
+			cfg.Depth, err = strconv.Atoi(value)
+		case "min_fan_out":
+			cfg.MinFanOut, err = strconv.Atoi(value)
+		case "max_fan_out":
+			cfg.MaxFanOut, err = strconv.Atoi(value)
+		case "epochs":
+			cfg.Epochs, err = strconv.Atoi(value)
+		case "weights":
+			cfg.Weights, err = generator.ParseDistribution(value)
+		case "mean_weight":
+			cfg.MeanWeight, err = strconv.ParseFloat(value, 64)
+		case "zipf_exponent":
+			cfg.ZipfExponent, err = strconv.ParseFloat(value, 64)
+		case "sigma":
+			cfg.Sigma, err = strconv.ParseFloat(value, 64)
+		case "noise":
+			cfg.Noise, err = strconv.ParseFloat(value, 64)
+		case "epochs_per_day":
+			cfg.EpochsPerDay, err = strconv.Atoi(value)
+		case "daily_amplitude":
+			cfg.DailyAmplitude, err = strconv.ParseFloat(value, 64)
+		case "weekly_amplitude":
+			cfg.WeeklyAmplitude, err = strconv.ParseFloat(value, 64)
+		case "growth":
+			cfg.Growth, err = strconv.ParseFloat(value, 64)
+		case "spike_probability":
+			cfg.SpikeProbability, err = strconv.ParseFloat(value, 64)
+		case "spike_factor":
+			cfg.SpikeFactor, err = strconv.ParseFloat(value, 64)
+		case "spike_length":
+			cfg.SpikeLength, err = strconv.Atoi(value)
+		case "format":
+			format, err = parseFormat(value, arg)
+		default:
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+
+		if err != nil {
+			return fmt.Errorf("error: invalid argument '%s': %v", arg, err)
+		}
+	}
+
+	if outDir == "" {
+		return errors.New("error: out not specified")
+	}
+
+	var newHierarchy, err = generator.Generate(cfg)
+	if err != nil {
+		return err
+	}
+
+	return writeHierarchy(newHierarchy, outDir, format)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+)
+
+// runImport writes a dataset built from slash-separated paths or from a local directory:
+//
+//	main import -paths=<file> -out=<dir> [-epoch_duration=D -format=csv/json]
+//	main import -dir=<dir> -out=<dir> [-files=true -max_depth=N -unit=N -hidden=true -format=csv/json]
+func runImport(args []string) error {
+	var dirOpts hierarchy.DirOptions
+	var pathsFile, dir, outDir string
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+		if ok, err := parseInputParameter(parameter, value, arg); ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch parameter {
+		case "paths":
+			pathsFile = value
+		case "dir":
+			dir = value
+		case "out":
+			outDir = value
+		case "files":
+			dirOpts.Files, err = strconv.ParseBool(value)
+		case "hidden":
+			dirOpts.Hidden, err = strconv.ParseBool(value)
+		case "max_depth":
+			dirOpts.MaxDepth, err = strconv.Atoi(value)
+		case "unit":
+			dirOpts.Unit, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+
+		if err != nil {
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+	}
+
+	if outDir == "" {
+		return errors.New("error: out not specified")
+	}
+	if (pathsFile == "") == (dir == "") {
+		return errors.New("error: either paths or dir must be specified")
+	}
+
+	var h *hierarchy.Hierarchy
+	var err error
+	if pathsFile != "" {
+		h, err = hierarchy.ReadPaths(pathsFile, hierarchyOptions)
+	} else {
+		h, err = hierarchy.ImportDirectory(dir, dirOpts)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeHierarchy(h, outDir, hierarchyOptions.Format)
+}
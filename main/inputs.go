@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+var datasetDir, treeFile, weightsFile, eventsFile string
+var hierarchyOptions = hierarchy.Options{CollectAll: true}
+
+// several roots are joined under a virtual super-root
+var forest bool
+var superRoot = tree.DefaultSuperRoot
+
+// parseInputParameter handles parameters selecting input files which are shared by commands.
+// It returns false if the parameter is not an input one.
+func parseInputParameter(parameter string, value string, arg string) (bool, error) {
+	switch parameter {
+	case "dataset":
+		if info, err := os.Stat(value); err != nil || !info.IsDir() {
+			return true, errors.New("error: dataset '" + value + "' is not a directory")
+		}
+		datasetDir = value
+
+	case "tree":
+		treeFile = value
+
+	case "weights":
+		weightsFile = value
+
+	case "events":
+		eventsFile = value
+
+	case "format":
+		var err error
+		hierarchyOptions.Format, err = parseFormat(value, arg)
+		return true, err
+
+	case "tree_columns", "weights_columns", "events_columns":
+		var fields = map[string][]string{"tree_columns": hierarchy.TreeFields,
+			"weights_columns": hierarchy.WeightsFields, "events_columns": hierarchy.EventsFields}
+		var columns, err = hierarchy.ParseColumns(value, fields[parameter])
+		if err != nil {
+			return true, err
+		}
+		switch parameter {
+		case "tree_columns":
+			hierarchyOptions.TreeColumns = columns
+		case "weights_columns":
+			hierarchyOptions.WeightsColumns = columns
+		case "events_columns":
+			hierarchyOptions.EventsColumns = columns
+		}
+
+	case "weights_layout":
+		var wide, err = parseLayout(value, arg)
+		hierarchyOptions.WideWeights = wide
+		return true, err
+
+	case "delimiter":
+		if value == "tab" || value == "\\t" {
+			value = "\t"
+		}
+		var runes = []rune(value)
+		if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+			return true, errors.New("error: unknown argument '" + arg + "'")
+		}
+		hierarchyOptions.Delimiter = runes[0]
+
+	case "header":
+		var header, err = strconv.ParseBool(value)
+		if err != nil {
+			return true, errors.New("error: unknown argument '" + arg + "'")
+		}
+		hierarchyOptions.NoHeader = !header
+
+	case "forest":
+		var err error
+		if forest, err = strconv.ParseBool(value); err != nil {
+			return true, errors.New("error: unknown argument '" + arg + "'")
+		}
+
+	case "super_root":
+		if value == "" {
+			return true, errors.New("error: unknown argument '" + arg + "'")
+		}
+		forest = true
+		superRoot = value
+
+	case "in_memory":
+		var err error
+		if hierarchyOptions.InMemory, err = strconv.ParseBool(value); err != nil {
+			return true, errors.New("error: unknown argument '" + arg + "'")
+		}
+
+	case "epoch_duration":
+		var d, err = time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return true, errors.New("error: unknown argument '" + arg + "'")
+		}
+		hierarchyOptions.Timestamps = true
+		hierarchyOptions.EpochDuration = d
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// newTree builds the tree, or joins all trees under the super-root with -forest.
+func newTree(childToParent map[string]string) (*tree.Node, error) {
+	if !forest {
+		return tree.NewTree(childToParent)
+	}
+
+	var roots, err = tree.NewForest(childToParent)
+	if err != nil {
+		return nil, err
+	}
+	return tree.NewSuperRoot(superRoot, roots)
+}
+
+func parseFormat(value string, arg string) (string, error) {
+	if value != hierarchy.FormatCsv && value != hierarchy.FormatJson {
+		return "", errors.New("error: unknown argument '" + arg + "'")
+	}
+	return value, nil
+}
+
+// parseLayout returns true for the wide layout of weights
+func parseLayout(value string, arg string) (bool, error) {
+	if value != "long" && value != "wide" {
+		return false, errors.New("error: unknown argument '" + arg + "'")
+	}
+	return value == "wide", nil
+}
+
+// datasetFiles returns the tree and weights files of a dataset directory in the format.
+func datasetFiles(dir string, format string) (string, string) {
+	if format == hierarchy.FormatJson {
+		return filepath.Join(dir, "ChildParent.json"), filepath.Join(dir, "WeightsPerEpoch.jsonl")
+	}
+	return filepath.Join(dir, "ChildParent.csv"), filepath.Join(dir, "WeightsPerEpoch.csv")
+}
+
+// findDatasetFiles returns the tree and weights files of a dataset directory.
+// Without -format a JSON dataset is used only if there is no CSV one.
+func findDatasetFiles(dir string) (string, string) {
+	var treePath, weightsPath = datasetFiles(dir, hierarchyOptions.Format)
+	if hierarchyOptions.Format == "" {
+		var jsonTree, jsonWeights = datasetFiles(dir, hierarchy.FormatJson)
+		if _, err := os.Stat(treePath); err != nil {
+			if _, err = os.Stat(jsonTree); err == nil {
+				return jsonTree, jsonWeights
+			}
+		}
+	}
+
+	return treePath, weightsPath
+}
+
+// resolveInputFiles takes files which are not given explicitly from the dataset directory.
+func resolveInputFiles() error {
+	if datasetDir != "" {
+		var datasetTree, datasetWeights = findDatasetFiles(datasetDir)
+		if treeFile == "" {
+			treeFile = datasetTree
+		}
+		if weightsFile == "" {
+			weightsFile = datasetWeights
+		}
+	}
+	if eventsFile == "" && datasetDir != "" {
+		if _, err := os.Stat(filepath.Join(datasetDir, "TopologyEvents.csv")); err == nil {
+			eventsFile = filepath.Join(datasetDir, "TopologyEvents.csv")
+		}
+	}
+
+	if treeFile == "" {
+		return errors.New("error: neither dataset nor tree specified")
+	}
+	if weightsFile == "" {
+		return errors.New("error: neither dataset nor weights specified")
+	}
+	if treeFile == hierarchy.Stdin && weightsFile == hierarchy.Stdin {
+		return errors.New("error: only one input can be read from stdin")
+	}
+
+	return nil
+}
+
+// readHierarchy loads the whole dataset into memory.
+func readHierarchy() (*hierarchy.Hierarchy, error) {
+	return hierarchy.NewHierarchyWithOptions(treeFile, weightsFile, hierarchyOptions)
+}
+
+// writeHierarchy writes a dataset directory in the format, CSV if it is empty.
+func writeHierarchy(h *hierarchy.Hierarchy, dir string, format string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if format == "" {
+		format = hierarchy.FormatCsv
+	}
+	var treePath, weightsPath = datasetFiles(dir, format)
+
+	return h.Write(treePath, weightsPath, format)
+}
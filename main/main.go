@@ -3,7 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -13,28 +15,56 @@ import (
 	"github.com/dati-mipt/dhsbpp/vizualize"
 )
 
-var datasetName string
+var outDir = "output"
+var windowSize int // epochs over which loads are aggregated, InitEpochs if 0
+var aggregation = hierarchy.AggregateSum
+var resampleFactor = 1
+var resourceFlags = make(map[string]packing.Resource) // additional resources by name
+var snapshotFormat string                             // format of partition tree snapshots, none if empty
 
-func parseParameters() error {
-	var isDataset, isAlgorithm, isSeparate, isMaxCapacity, isInitEpochs bool
+// subcommands, the packing simulation runs when none is given
+var commands = map[string]func(args []string) error{
+	"generate": runGenerate,
+	"stats":    runStats,
+	"slice":    runSlice,
+	"import":   runImport,
+	"convert":  runConvert,
+	"validate": runValidate,
+	"diff":     runDiff,
+}
 
-	for idx := 1; idx < len(os.Args); idx++ {
-		var arg = os.Args[idx]
-		if arg[0] != '-' {
-			return errors.New("error: unknown argument '" + arg + "'")
-		}
-		var slice = strings.Split(arg[1:], "=")
-		if len(slice) != 2 {
-			return errors.New("error: unknown argument '" + arg + "'")
+// splitArgument splits "-parameter=value"
+func splitArgument(arg string) (string, string, error) {
+	if len(arg) == 0 || arg[0] != '-' {
+		return "", "", errors.New("error: unknown argument '" + arg + "'")
+	}
+	var slice = strings.SplitN(arg[1:], "=", 2)
+	if len(slice) != 2 {
+		return "", "", errors.New("error: unknown argument '" + arg + "'")
+	}
+
+	return slice[0], slice[1], nil
+}
+
+func parseParameters(args []string) error {
+	var isAlgorithm, isSeparate, isMaxCapacity, isInitEpochs bool
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
 		}
 
-		var parameter, value = slice[0], slice[1]
+		if ok, err := parseInputParameter(parameter, value, arg); ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
 		switch parameter {
-		case "dataset":
-			isDataset = true
-			datasetName = value
-			// check dir
+		case "out":
+			outDir = value
 
 		case "algorithm":
 			isAlgorithm = true
@@ -87,11 +117,85 @@ func parseParameters() error {
 				return errors.New("error: unknown argument '" + arg + "'")
 			}
 			packing.ReallocationDelta = n
+
+		case "resource":
+			var resource, err = parseResource(value)
+			if err != nil {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+			resourceFlags[resource.Name] = resource
+			hierarchyOptions.MultiResource = true
+
+		case "unknown":
+			switch value {
+			case "fail":
+				packing.UnknownNodes.Policy = tree.FailOnUnknown
+			case "drop":
+				packing.UnknownNodes.Policy = tree.DropUnknown
+			case "attach":
+				packing.UnknownNodes.Policy = tree.AttachUnknown
+			default:
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+
+		case "window":
+			var n, err = strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+			windowSize = n
+		case "aggregate":
+			var err error
+			if aggregation, err = hierarchy.ParseAggregation(value); err != nil {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+		case "resample":
+			var n, err = strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+			resampleFactor = n
+
+		case "seed":
+			var n, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+			packing.Rand = rand.New(rand.NewSource(n))
+
+		case "debug":
+			var err error
+			if packing.Debug, err = strconv.ParseBool(value); err != nil {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+
+		case "snapshot":
+			if value != "json" && value != "binary" {
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
+			snapshotFormat = value
+
+		case "unknown_parent":
+			packing.UnknownNodes.Parent = value
+
+		case "order":
+			switch value {
+			case "size":
+				packing.SizeOrderFunc = packing.OrderBySize
+			case "max":
+				packing.SizeOrderFunc = packing.OrderByMaxDimension
+			case "l2":
+				packing.SizeOrderFunc = packing.OrderByL2Norm
+			case "dominant":
+				packing.SizeOrderFunc = packing.OrderByDominantResource
+			default:
+				return errors.New("error: unknown argument '" + arg + "'")
+			}
 		}
 	}
 
-	if !isDataset {
-		return errors.New("error: datasetName not specified")
+	if err := resolveInputFiles(); err != nil {
+		return err
 	}
 	if !isAlgorithm {
 		return errors.New("error: algorithm not specified")
@@ -110,72 +214,228 @@ func parseParameters() error {
 }
 
 func main() {
-	var err = parseParameters()
-	packing.UpdParams()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				printError(err)
+			}
+			return
+		}
+	}
+
+	packing.UnknownNodes = &tree.UnknownNodes{Policy: tree.FailOnUnknown}
+	var err = parseParameters(os.Args[1:])
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	var datasetPath = os.Getenv("HOME") + "/go/src/github.com/dati-mipt/dhsbpp/datasets/" + datasetName
-	fmt.Println(datasetPath)
-	var newHierarchy = hierarchy.NewHierarchy(datasetPath+"/ChildParent.csv",
-		datasetPath+"/WeightsPerEpoch.csv")
+	var opts = hierarchyOptions
+	childToParent, err := hierarchy.ReadTreeNodes(treeFile, opts)
+	if err != nil {
+		printError(err)
+		return
+	}
 
-	root, err := tree.NewTree(newHierarchy.ChildToParent)
+	epochs, err := hierarchy.OpenEpochSource(weightsFile, opts)
+	if err != nil {
+		printError(err)
+		return
+	}
+	defer epochs.Close()
+
+	if err = setExtraResources(epochs.Resources()); err != nil {
+		fmt.Println(err)
+		return
+	}
+	packing.UpdParams()
+
+	var source = hierarchy.Resample(epochs, resampleFactor)
+	if windowSize == 0 {
+		windowSize = packing.InitEpochs
+	}
+	var window = hierarchy.NewEpochWindow(windowSize, aggregation)
+	if err = window.Fill(source, packing.InitEpochs); err != nil {
+		printError(err)
+		return
+	}
+
+	var events *hierarchy.EventQueue
+	if eventsFile != "" {
+		events, err = hierarchy.ReadTopologyEvents(eventsFile, opts)
+		if err != nil {
+			printError(err)
+			return
+		}
+	}
+
+	root, err := newTree(childToParent)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
 	var pRoot = tree.NewPartitionTree(root)
+	if packing.Rand != nil {
+		pRoot.ShuffleChildren(packing.Rand)
+	}
 
-	err = pRoot.SetInitialSize(newHierarchy.WeightsPerEpoch, packing.InitEpochs)
+	nameToPartitionNode, _ := pRoot.MapNameToPartitionNode()
+	err = packing.ApplyTopologyEvents(nil, events.PopUntil(window.Last), nameToPartitionNode)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	err = pRoot.SetInitialSize([]map[string]int64{window.Loads()}, 1, packing.UnknownNodes)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(packing.ExtraResources) > 0 {
+		err = pRoot.SetInitialExtraSize([]map[string][]int64{window.ExtraLoads()}, 1, packing.UnknownNodes)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
 	packing.PreprocessPartitionTree(pRoot)
 	var bins = make([]*packing.Bin, 0)
 	bins = packing.AlgorithmPackingFunc(pRoot, bins)
 
-	var picsPath = os.Getenv("HOME") + "/go/src/github.com/dati-mipt/dhsbpp/outputPics/"
-	err = vizualize.MakeVisualizationPicture(bins, "1distribution.png", picsPath)
-	if err != nil {
+	if err = os.MkdirAll(outDir, 0755); err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	nameToPartitionNode, _ := pRoot.MapNameToPartitionNode()
-	var loadedBin = packing.FindBinForRebalancing(bins, newHierarchy.WeightsPerEpoch, nameToPartitionNode)
-
-	err = vizualize.MakeVisualizationPicture(bins, "2distribution.png", picsPath)
+	err = vizualize.MakeVisualizationPicture(bins, "1distribution.png", outDir)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	var sum int64
-	for _, bin := range bins {
-		sum += bin.Size
+	if err = writeSnapshot(pRoot, 1); err != nil {
+		fmt.Println(err)
+		return
 	}
-	fmt.Println(sum, "1")
-	if loadedBin != nil {
+
+	nameToPartitionNode, _ = pRoot.MapNameToPartitionNode()
+	for step := 1; ; step++ {
+		loadedBin, err := packing.FindBinForRebalancing(bins, source, window, events, nameToPartitionNode)
+		if err != nil {
+			printError(err)
+			return
+		}
+		if loadedBin == nil {
+			break
+		}
+
+		err = vizualize.MakeVisualizationPicture(bins, fmt.Sprintf("%ddistribution.png", 2*step), outDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
 		var migrationSize int64
 		bins, migrationSize = packing.DynamicalAlgorithmPackingFunc(loadedBin, bins)
 		fmt.Println("Number of bins", len(bins))
 		fmt.Println("Bin Index:", loadedBin.Index)
 		fmt.Println("Migration Size:", migrationSize)
+		fmt.Println("Splits:", packing.Splits(bins))
 
-		err = vizualize.MakeVisualizationPicture(bins, "3distribution.png", picsPath)
+		err = vizualize.MakeVisualizationPicture(bins, fmt.Sprintf("%ddistribution.png", 2*step+1), outDir)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
+		if err = writeSnapshot(pRoot, 2*step+1); err != nil {
+			fmt.Println(err)
+			return
+		}
 	}
-	sum = 0
-	for _, bin := range bins {
-		sum += bin.Size
+
+	fmt.Println("Processed", epochs.Range())
+	if summary := packing.UnknownNodes.Summary(); summary != "" {
+		fmt.Println(summary)
+	}
+}
+
+// writeSnapshot saves the partition tree next to the picture of the same number
+func writeSnapshot(pRoot *tree.PartitionNode, number int) error {
+	if snapshotFormat == "" {
+		return nil
+	}
+
+	var name = fmt.Sprintf("%dpartition.json", number)
+	if snapshotFormat == "binary" {
+		name = fmt.Sprintf("%dpartition.bin", number)
+	}
+	var file, err = os.Create(filepath.Join(outDir, name))
+	if err != nil {
+		return err
+	}
+
+	if snapshotFormat == "binary" {
+		err = pRoot.WriteBinary(file)
+	} else {
+		err = pRoot.WriteJSON(file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// parseResource parses "name:capacity[:AF[:RD]]"
+func parseResource(value string) (packing.Resource, error) {
+	var fields = strings.Split(value, ":")
+	if len(fields) < 2 || len(fields) > 4 || fields[0] == "" {
+		return packing.Resource{}, errors.New("invalid resource")
+	}
+
+	var resource = packing.Resource{Name: fields[0]}
+	var numbers = []*int64{&resource.MaxCapacity, &resource.AllocationFactor, &resource.ReallocationDelta}
+	for idx, field := range fields[1:] {
+		var n, err = strconv.ParseInt(field, 10, 64)
+		if err != nil || n <= 0 {
+			return packing.Resource{}, errors.New("invalid resource")
+		}
+		*numbers[idx] = n
+	}
+
+	return resource, nil
+}
+
+// setExtraResources configures packing for weight columns after the first one.
+func setExtraResources(resources []string) error {
+	packing.ExtraResources = nil
+	for _, name := range resources[1:] {
+		var resource, ok = resourceFlags[name]
+		if !ok {
+			return errors.New("error: resource '" + name + "' not specified")
+		}
+		if resource.AllocationFactor == 0 {
+			resource.AllocationFactor = packing.AllocationFactor
+		}
+		if resource.ReallocationDelta == 0 {
+			resource.ReallocationDelta = packing.ReallocationDelta
+		}
+		packing.ExtraResources = append(packing.ExtraResources, resource)
+	}
+	if len(packing.ExtraResources) != len(resourceFlags) {
+		return errors.New("error: resource not found in weights")
+	}
+
+	return nil
+}
+
+func printError(err error) {
+	if list, ok := err.(hierarchy.ErrorList); ok {
+		for _, e := range list {
+			fmt.Println(e)
+		}
+	} else {
+		fmt.Println(err)
 	}
-	fmt.Println(sum, "1")
 }
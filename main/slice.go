@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+)
+
+// runSlice writes a part of a dataset in the same format:
+//
+//	main slice -dataset=<dir> -out=<dir> [-root=name -first_epoch=N -last_epoch=N -leaves=N -seed=N -out_format=csv/json]
+func runSlice(args []string) error {
+	var opts hierarchy.SliceOptions
+	var epochs = hierarchy.EpochRange{First: math.MinInt, Last: math.MaxInt}
+	var hasEpochs bool
+	var outDir, outFormat string
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+		if ok, err := parseInputParameter(parameter, value, arg); ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch parameter {
+		case "out":
+			outDir = value
+		case "out_format":
+			outFormat, err = parseFormat(value, arg)
+		case "root":
+			opts.Root = value
+		case "first_epoch":
+			epochs.First, err = strconv.Atoi(value)
+			hasEpochs = true
+		case "last_epoch":
+			epochs.Last, err = strconv.Atoi(value)
+			hasEpochs = true
+		case "leaves":
+			opts.Leaves, err = strconv.Atoi(value)
+		case "seed":
+			opts.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+
+		if err != nil {
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+	}
+
+	if outDir == "" {
+		return errors.New("error: out not specified")
+	}
+	if err := resolveInputFiles(); err != nil {
+		return err
+	}
+	if hasEpochs {
+		opts.Epochs = &epochs
+	}
+
+	var h, err = readHierarchy()
+	if err != nil {
+		return err
+	}
+	sliced, err := h.Slice(opts)
+	if err != nil {
+		return err
+	}
+
+	if outFormat == "" {
+		outFormat = hierarchy.FormatOf(treeFile, hierarchyOptions)
+	}
+	return writeHierarchy(sliced, outDir, outFormat)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/dati-mipt/dhsbpp/stats"
+)
+
+// runStats prints statistics of a dataset:
+//
+//	main stats -dataset=<dir> [-max_capacity=N -AF=N -init_epochs=N -top=N -format=text/json]
+func runStats(args []string) error {
+	var opts = stats.Options{Top: 10, AF: 60, Window: 1}
+	var format = "text"
+
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+		if parameter != "format" { // -format selects the report format here
+			if ok, err := parseInputParameter(parameter, value, arg); ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		var n int64
+		switch parameter {
+		case "max_capacity":
+			n, err = strconv.ParseInt(value, 10, 64)
+			opts.MaxCapacity = n
+		case "AF":
+			n, err = strconv.ParseInt(value, 10, 64)
+			opts.AF = n
+		case "init_epochs":
+			n, err = strconv.ParseInt(value, 10, 64)
+			opts.Window = int(n)
+		case "top":
+			n, err = strconv.ParseInt(value, 10, 64)
+			opts.Top = int(n)
+		case "format":
+			if value != "text" && value != "json" {
+				err = errors.New("unknown format")
+			}
+			format = value
+		default:
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+
+		if err != nil || n < 0 {
+			return errors.New("error: unknown argument '" + arg + "'")
+		}
+	}
+
+	if err := resolveInputFiles(); err != nil {
+		return err
+	}
+	var h, err = readHierarchy()
+	if err != nil {
+		return err
+	}
+	root, err := newTree(h.ChildToParent)
+	if err != nil {
+		return err
+	}
+
+	report, err := stats.Compute(h, root, opts)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.WriteText(os.Stdout)
+}
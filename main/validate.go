@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// runValidate prints everything which prevents the tree file from forming one tree,
+// or one or more trees with -forest:
+//
+//	main validate -dataset=<dir> | -tree=<file> [-forest=true]
+func runValidate(args []string) error {
+	for _, arg := range args {
+		var parameter, value, err = splitArgument(arg)
+		if err != nil {
+			return err
+		}
+		if ok, err := parseInputParameter(parameter, value, arg); !ok {
+			return errors.New("error: unknown argument '" + arg + "'")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if treeFile == "" && datasetDir != "" {
+		treeFile, _ = findDatasetFiles(datasetDir)
+	}
+	if treeFile == "" {
+		return errors.New("error: neither dataset nor tree specified")
+	}
+
+	var rows, err = hierarchy.ReadTreeRows(treeFile, hierarchyOptions)
+	if err != nil {
+		return err
+	}
+	var edges = make([]tree.Edge, 0, len(rows))
+	for _, row := range rows {
+		edges = append(edges, tree.Edge{Child: row.Child, Parent: row.Parent, Line: row.Line})
+	}
+
+	var report = tree.Validate(edges)
+	report.Forest = forest
+	if err = report.Err(); err != nil {
+		return err
+	}
+	if len(report.Roots) > 1 {
+		fmt.Printf("forest is valid: %d nodes, %d roots %s\n", report.Nodes, len(report.Roots),
+			strings.Join(report.Roots, ", "))
+	} else {
+		fmt.Printf("tree is valid: %d nodes, root %s\n", report.Nodes, report.Roots[0])
+	}
+	for _, duplicate := range report.Duplicates {
+		fmt.Printf("warning: %s has %d equal rows at lines %v\n", duplicate.Child, len(duplicate.Lines), duplicate.Lines)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package packing
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// CompactPacking places nodes of a compact tree into bins. Instead of PartNodes
+// of every Bin it keeps the bin of every node in an array indexed by node id.
+// It is a library API for packing huge static trees: the simulation packs
+// PartitionNode trees, since rebalancing and topology events move subtrees
+// and a compact tree can't change its shape.
+type CompactPacking struct {
+	Tree  *tree.CompactTree
+	BinOf []int32 // index of the bin of every node, 0 for the virtual super-root
+	Sizes []int64 // Sizes[idx-1] is the size of the bin with index idx
+
+	size     []int64 // subtree sizes without separated parts while packing
+	detached []bool  // separated from the parent until united back
+}
+
+// CompactSeparateFunc is SeparateFunc for a compact tree: it separates parts of
+// the subtree of the node in the packing and returns them in the order of packing
+// together with the parts to unite back.
+type CompactSeparateFunc func(p *CompactPacking, id tree.NodeID) ([]tree.NodeID, []tree.NodeID)
+
+// return the number of bins
+func (p *CompactPacking) Bins() int {
+	return len(p.Sizes)
+}
+
+// Splits counts nodes placed into another bin than their parent, see Splits.
+func (p *CompactPacking) Splits() int {
+	var splits int
+	for id, node := range p.Tree.Nodes {
+		if node.Parent != tree.NoNode && !p.Tree.Nodes[node.Parent].Virtual && p.BinOf[id] != p.BinOf[node.Parent] {
+			splits++
+		}
+	}
+
+	return splits
+}
+
+// PreprocessCompactTree is PreprocessPartitionTree for a compact tree.
+func PreprocessCompactTree(t *tree.CompactTree) *tree.CompactTree {
+	return t.SplitLarge(Volume)
+}
+
+// CompactFirstFitDecreasing is HierarchicalFirstFitDecreasing on a compact tree.
+// The bins are the same as of the partition tree the compact one is copied from
+// by tree.NewCompactTreeOf when separate matches SeparateFunc.
+func CompactFirstFitDecreasing(t *tree.CompactTree, separate CompactSeparateFunc) (*CompactPacking, error) {
+	return packCompact(t, false, separate)
+}
+
+// CompactGreedyDecreasing is HierarchicalGreedyDecreasing on a compact tree.
+func CompactGreedyDecreasing(t *tree.CompactTree, separate CompactSeparateFunc) (*CompactPacking, error) {
+	return packCompact(t, true, separate)
+}
+
+func packCompact(t *tree.CompactTree, greedy bool, separate CompactSeparateFunc) (*CompactPacking, error) {
+	if len(ExtraResources) > 0 {
+		return nil, errors.New("packing : compact trees hold a single resource")
+	}
+
+	var p = &CompactPacking{
+		Tree:     t,
+		BinOf:    make([]int32, t.Len()),
+		size:     make([]int64, t.Len()),
+		detached: make([]bool, t.Len()),
+	}
+	for id, node := range t.Nodes {
+		p.size[id] = node.SubTreeSize
+	}
+	if t.Len() > 0 {
+		p.pack(0, greedy, separate)
+	}
+	p.size, p.detached = nil, nil
+
+	return p, nil
+}
+
+// pack follows packDecreasing with node ids
+func (p *CompactPacking) pack(root tree.NodeID, greedy bool, separateFunc CompactSeparateFunc) {
+	type frame struct {
+		id       tree.NodeID
+		separate []tree.NodeID
+		forUnite []tree.NodeID
+		next     int
+	}
+	var stack []*frame
+	var pack = func(id tree.NodeID) {
+		switch {
+		case p.Tree.Nodes[id].Virtual:
+			var trees = p.attachedChildren(id)
+			p.sortDecreasing(trees)
+			stack = append(stack, &frame{id: id, separate: trees})
+		case p.size[id] <= Volume:
+			p.place(id, greedy)
+		default:
+			var separate, forUnite = separateFunc(p, id)
+			stack = append(stack, &frame{id: id, separate: separate, forUnite: forUnite})
+		}
+	}
+
+	pack(root)
+	for len(stack) > 0 {
+		var top = stack[len(stack)-1]
+		if top.next < len(top.separate) {
+			top.next++
+			pack(top.separate[top.next-1])
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		p.unite(top.id, top.forUnite)
+	}
+}
+
+// place puts the subtree without separated parts into the first bin it fits,
+// or into a new bin
+func (p *CompactPacking) place(root tree.NodeID, greedy bool) {
+	var bin = -1
+	if !greedy {
+		for idx, size := range p.Sizes {
+			if p.size[root] <= Volume-size {
+				bin = idx
+				break
+			}
+		}
+	}
+	if bin < 0 {
+		p.Sizes = append(p.Sizes, 0)
+		bin = len(p.Sizes) - 1
+	}
+
+	p.Sizes[bin] += p.size[root]
+	var nodes = p.Tree.Nodes
+	for id := root; id < nodes[root].End; {
+		if id != root && p.detached[id] {
+			id = nodes[id].End
+			continue
+		}
+		p.BinOf[id] = int32(bin + 1)
+		id++
+	}
+}
+
+// CompactSeparateRoot is SeparateRoot for a compact tree.
+func CompactSeparateRoot(p *CompactPacking, id tree.NodeID) ([]tree.NodeID, []tree.NodeID) {
+	var children = p.attachedChildren(id)
+	if len(children) == 0 {
+		unseparable("CompactSeparateRoot", p.Tree.Name(id))
+	}
+	for _, child := range children {
+		p.detached[child] = true
+	}
+	p.size[id] = p.Tree.Nodes[id].NodeSize
+	var separate = append(append(make([]tree.NodeID, 0, len(children)+1), children...), id)
+	p.sortDecreasing(separate)
+
+	return separate, children
+}
+
+// CompactSeparateMaxChild is SeparateMaxChild for a compact tree.
+func CompactSeparateMaxChild(p *CompactPacking, id tree.NodeID) ([]tree.NodeID, []tree.NodeID) {
+	var maxChild = tree.NoNode
+	var maxSize float64 = 0
+	var ties int
+	for _, child := range p.attachedChildren(id) {
+		var order = p.order(child)
+		switch {
+		case maxChild == tree.NoNode || order > maxSize:
+			maxChild, maxSize, ties = child, order, 1
+		case order == maxSize:
+			ties++
+			if Rand == nil || Rand.Intn(ties) == 0 {
+				maxChild = child
+			}
+		}
+	}
+	if maxChild == tree.NoNode {
+		unseparable("CompactSeparateMaxChild", p.Tree.Name(id))
+	}
+
+	p.detached[maxChild] = true
+	p.size[id] -= p.size[maxChild]
+	if p.order(maxChild) > p.order(id) {
+		return []tree.NodeID{maxChild, id}, []tree.NodeID{maxChild}
+	}
+	return []tree.NodeID{id, maxChild}, []tree.NodeID{maxChild}
+}
+
+func (p *CompactPacking) unite(id tree.NodeID, children []tree.NodeID) {
+	for _, child := range children {
+		p.detached[child] = false
+		p.size[id] += p.size[child]
+	}
+}
+
+func (p *CompactPacking) attachedChildren(id tree.NodeID) []tree.NodeID {
+	var children []tree.NodeID
+	for child := p.Tree.FirstChild(id); child != tree.NoNode; child = p.Tree.NextSibling(child) {
+		if !p.detached[child] {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (p *CompactPacking) order(id tree.NodeID) float64 {
+	return SizeOrderFunc(p.size[id], nil)
+}
+
+// sortDecreasing is sortDecreasing for node ids
+func (p *CompactPacking) sortDecreasing(ids []tree.NodeID) {
+	if Rand != nil {
+		Rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return p.order(ids[i]) > p.order(ids[j])
+	})
+}
@@ -0,0 +1,129 @@
+package packing
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// randomDataset returns a child to parent map of a random tree and weights of its nodes
+func randomDataset(count int) (map[string]string, map[string]int64) {
+	var r = rand.New(rand.NewSource(1))
+	var childToParent = map[string]string{"0": "0"}
+	var weights = map[string]int64{"0": r.Int63n(1000)}
+	for idx := 1; idx < count; idx++ {
+		childToParent[strconv.Itoa(idx)] = strconv.Itoa(r.Intn(idx))
+		weights[strconv.Itoa(idx)] = r.Int63n(1000)
+	}
+	return childToParent, weights
+}
+
+func buildPartitionTree(tb testing.TB, childToParent map[string]string, weights map[string]int64) *tree.PartitionNode {
+	var root, err = tree.NewTree(childToParent)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var pRoot = tree.NewPartitionTree(root)
+	if err = pRoot.SetInitialSize([]map[string]int64{weights}, 1, nil); err != nil {
+		tb.Fatal(err)
+	}
+	return pRoot
+}
+
+func buildCompactTree(tb testing.TB, childToParent map[string]string, weights map[string]int64) *tree.CompactTree {
+	var t, err = tree.NewCompactTree(childToParent)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	t.SetSizes(weights)
+	return t
+}
+
+func TestCompactPackingMatchesPartitionTree(t *testing.T) {
+	MaxCapacity = 100000
+	UpdParams()
+	var childToParent, weights = randomDataset(10000)
+
+	for _, separate := range []struct {
+		pointer func(pNode *tree.PartitionNode) ([]*tree.PartitionNode, []*tree.PartitionNode)
+		compact CompactSeparateFunc
+	}{
+		{SeparateRoot, CompactSeparateRoot},
+		{SeparateMaxChild, CompactSeparateMaxChild},
+	} {
+		var pRoot = buildPartitionTree(t, childToParent, weights)
+		PreprocessPartitionTree(pRoot)
+		var ct = tree.NewCompactTreeOf(pRoot)
+		var nodes []*tree.PartitionNode // in the order of ids, Unite changes the order of children
+		pRoot.PreOrder(func(pNode *tree.PartitionNode, _ int) bool {
+			nodes = append(nodes, pNode)
+			return true
+		})
+		SeparateFunc = separate.pointer
+		var bins = HierarchicalFirstFitDecreasing(pRoot, nil)
+		var p, err = CompactFirstFitDecreasing(ct, separate.compact)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Bins() != len(bins) || p.Splits() != Splits(bins) {
+			t.Fatalf("compact: %d bins, %d splits, partition tree: %d bins, %d splits",
+				p.Bins(), p.Splits(), len(bins), Splits(bins))
+		}
+		for id, pNode := range nodes {
+			if !bins[p.BinOf[id]-1].PartNodes[pNode] {
+				t.Fatalf("node %q is in bin %d of the compact packing", pNode.Name, p.BinOf[id])
+			}
+		}
+	}
+}
+
+const benchmarkNodes = 100000
+
+func BenchmarkBuildPartitionTree(b *testing.B) {
+	var childToParent, weights = randomDataset(benchmarkNodes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildPartitionTree(b, childToParent, weights)
+	}
+}
+
+func BenchmarkBuildCompactTree(b *testing.B) {
+	var childToParent, weights = randomDataset(benchmarkNodes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildCompactTree(b, childToParent, weights)
+	}
+}
+
+func BenchmarkPackPartitionTree(b *testing.B) {
+	MaxCapacity = 100000
+	UpdParams()
+	SeparateFunc = SeparateMaxChild
+	var childToParent, weights = randomDataset(benchmarkNodes)
+	var pRoot = buildPartitionTree(b, childToParent, weights)
+	PreprocessPartitionTree(pRoot)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		HierarchicalFirstFitDecreasing(pRoot, nil)
+	}
+}
+
+func BenchmarkPackCompactTree(b *testing.B) {
+	MaxCapacity = 100000
+	UpdParams()
+	var childToParent, weights = randomDataset(benchmarkNodes)
+	var t = PreprocessCompactTree(buildCompactTree(b, childToParent, weights))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CompactFirstFitDecreasing(t, CompactSeparateMaxChild); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package packing
+
+import (
+	"fmt"
+
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// Debug makes every operation changing the partition tree check the tree
+// it touched with tree.Check and panic with the offending nodes when sizes
+// or parents are broken. Each check walks the whole tree, so it is slow.
+var Debug bool
+
+// debugCheck checks the tree of the node after the operation when Debug is set.
+// Parts separated during packing are checked as trees of their own.
+func debugCheck(operation string, pNode *tree.PartitionNode) {
+	if !Debug || pNode == nil {
+		return
+	}
+
+	var root = pNode
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	if err := tree.Check(root).Err(); err != nil {
+		panic(fmt.Sprintf("packing : %s of %q broke the tree\n%v", operation, pNode.Name, err))
+	}
+}
+
+// unseparable panics for a node which is too large for a bin and has no children
+// to separate. PreprocessPartitionTree makes every node itself fit into a bin,
+// so packing a node like this would never end.
+func unseparable(operation string, name string) {
+	panic(fmt.Sprintf("packing : %s of %q: the node doesn't fit into a bin and has no children, "+
+		"the tree is not preprocessed", operation, name))
+}
@@ -2,10 +2,18 @@ package packing
 
 import (
 	"fmt"
+	"github.com/dati-mipt/dhsbpp/hierarchy"
 	"github.com/dati-mipt/dhsbpp/tree"
+	"io"
+	"math/rand"
 	"sort"
 )
 
+// Rand breaks ties between subtrees of equal size randomly when set.
+// Otherwise packing is deterministic: ties keep the order of children,
+// SeparateMaxChild takes the last of equal children, roots of a bin are taken by name.
+var Rand *rand.Rand
+
 var AlgorithmPackingFunc func(*tree.PartitionNode, []*Bin) []*Bin
 var SeparateFunc func(pNode *tree.PartitionNode) ([]*tree.PartitionNode, []*tree.PartitionNode)
 
@@ -14,6 +22,9 @@ var ReallocationDelta int64 = 20 // default value
 var MaxCapacity int64
 var InitEpochs int
 
+// UnknownNodes handles weights of nodes missing in the tree, nil drops them silently.
+var UnknownNodes *tree.UnknownNodes
+
 var Volume int64
 var OverloadThreshold int64
 var UnderloadThreshold int64
@@ -22,11 +33,13 @@ func UpdParams() {
 	Volume = MaxCapacity * AllocationFactor / 100
 	OverloadThreshold = MaxCapacity * (AllocationFactor + ReallocationDelta) / 100
 	UnderloadThreshold = MaxCapacity * (AllocationFactor - ReallocationDelta) / 100
+	updResourceParams()
 }
 
 type Bin struct {
 	Index     int
 	Size      int64
+	ExtraSize []int64 // sizes of ExtraResources
 	PartNodes map[*tree.PartitionNode]bool
 }
 
@@ -42,10 +55,18 @@ func NewBin(idx int) *Bin {
 
 func (bin *Bin) AddToBinSize(pNode *tree.PartitionNode, tasks int64) {
 	pNode.AddToNodeSize(tasks)
+	debugCheck("AddToBinSize", pNode)
 
 	bin.Size += tasks
 }
 
+func (bin *Bin) AddToBinExtraSize(pNode *tree.PartitionNode, extra []int64) {
+	pNode.AddToExtraNodeSize(extra)
+	debugCheck("AddToBinExtraSize", pNode)
+
+	tree.AddVector(&bin.ExtraSize, extra, 1)
+}
+
 func (bin *Bin) MakeMapRootNodesOfBin() map[*tree.PartitionNode]bool {
 	var tmpPartNodes = make(map[*tree.PartitionNode]bool)
 	for node := range bin.PartNodes {
@@ -61,51 +82,78 @@ func (bin *Bin) MakeMapRootNodesOfBin() map[*tree.PartitionNode]bool {
 	return tmpPartNodes
 }
 
+// return roots of the subtrees of the bin sorted by name
 func (bin *Bin) MakeSliceRootNodesOfBin() []*tree.PartitionNode {
 	var sliceRootNodesOfBin = make([]*tree.PartitionNode, 0)
 	for rootNode := range bin.MakeMapRootNodesOfBin() {
 		sliceRootNodesOfBin = append(sliceRootNodesOfBin, rootNode)
 	}
+	sort.Slice(sliceRootNodesOfBin, func(i, j int) bool {
+		return sliceRootNodesOfBin[i].Name < sliceRootNodesOfBin[j].Name
+	})
 
 	return sliceRootNodesOfBin
 }
 
 func (bin *Bin) AddSubTree(pNode *tree.PartitionNode) {
 	bin.Size += pNode.SubTreeSize
-	bin.addNodes(pNode)
-}
-
-func (bin *Bin) addNodes(pNode *tree.PartitionNode) {
-	bin.PartNodes[pNode] = true
-
-	for _, child := range pNode.Children {
-		bin.addNodes(child)
-	}
+	tree.AddVector(&bin.ExtraSize, pNode.ExtraSubTreeSize, 1)
+	pNode.PreOrder(func(pNode *tree.PartitionNode, _ int) bool {
+		bin.PartNodes[pNode] = true
+		return true
+	})
 }
 
 func (bin *Bin) freeBin() {
 	bin.PartNodes = make(map[*tree.PartitionNode]bool) // garbage collector?
 	bin.Size = 0
+	bin.ExtraSize = nil
 }
 
+// PreprocessPartitionTree splits nodes which don't fit into a bin:
+// the node keeps the volume, the rest is moved to a chunk child.
 func PreprocessPartitionTree(pRoot *tree.PartitionNode) {
-	if pRoot.NodeSize > Volume {
+	pRoot.PreOrder(func(pNode *tree.PartitionNode, _ int) bool {
+		preprocessPartitionNode(pNode)
+		return true // the chunk is walked as a child
+	})
+}
+
+func preprocessPartitionNode(pRoot *tree.PartitionNode) {
+	var isLarge = pRoot.NodeSize > Volume
+	for idx, resource := range ExtraResources {
+		isLarge = isLarge || extraAt(pRoot.ExtraNodeSize, idx) > resource.Volume
+	}
+
+	if isLarge {
+		var keptSize = min64(pRoot.NodeSize, Volume)
+		var keptExtra []int64
+		for idx, resource := range ExtraResources {
+			keptExtra = append(keptExtra, min64(extraAt(pRoot.ExtraNodeSize, idx), resource.Volume))
+		}
 
 		rootChunk := tree.PartitionNode{Name: pRoot.Name + "#", Parent: pRoot, Children: pRoot.Children,
-			NodeSize: pRoot.NodeSize - Volume, SubTreeSize: pRoot.SubTreeSize - Volume}
+			NodeSize: pRoot.NodeSize - keptSize, SubTreeSize: pRoot.SubTreeSize - keptSize}
+		if len(ExtraResources) > 0 {
+			tree.AddVector(&rootChunk.ExtraNodeSize, pRoot.ExtraNodeSize, 1)
+			tree.AddVector(&rootChunk.ExtraNodeSize, keptExtra, -1)
+			tree.AddVector(&rootChunk.ExtraSubTreeSize, pRoot.ExtraSubTreeSize, 1)
+			tree.AddVector(&rootChunk.ExtraSubTreeSize, keptExtra, -1)
+			pRoot.ExtraNodeSize = keptExtra
+		}
+		for _, child := range rootChunk.Children {
+			child.Parent = &rootChunk
+		}
 
-		pRoot.NodeSize = Volume
+		pRoot.NodeSize = keptSize
 		pRoot.Children = nil
 		pRoot.Children = append(pRoot.Children, &rootChunk)
-	}
-
-	for _, child := range pRoot.Children {
-		PreprocessPartitionTree(child)
+		debugCheck("PreprocessPartitionTree", pRoot)
 	}
 }
 
 func HierarchicalFirstFitDecreasing(pNode *tree.PartitionNode, bins []*Bin) []*Bin {
-	if pNode.SubTreeSize <= Volume {
+	return packDecreasing(pNode, bins, func(pNode *tree.PartitionNode, bins []*Bin) []*Bin {
 		var bin = findBinForFit(bins, pNode)
 
 		if bin != nil {
@@ -115,33 +163,58 @@ func HierarchicalFirstFitDecreasing(pNode *tree.PartitionNode, bins []*Bin) []*B
 			bin.AddSubTree(pNode)
 			bins = append(bins, bin)
 		}
-	} else {
-		//	var separate, forUnite = pNode.SeparateMaxChild()
-		var separate, forUnite = SeparateFunc(pNode)
-
-		for _, node := range separate {
-			bins = HierarchicalFirstFitDecreasing(node, bins)
-		}
-
-		Unite(pNode, forUnite)
-	}
-
-	return bins
+		return bins
+	})
 }
 
 func HierarchicalGreedyDecreasing(pNode *tree.PartitionNode, bins []*Bin) []*Bin {
-	if pNode.SubTreeSize <= Volume {
+	return packDecreasing(pNode, bins, func(pNode *tree.PartitionNode, bins []*Bin) []*Bin {
 		var bin = NewBin(len(bins) + 1)
 		bin.AddSubTree(pNode)
-		bins = append(bins, bin)
-	} else {
-		var separate, forUnite = SeparateFunc(pNode)
+		return append(bins, bin)
+	})
+}
+
+// packDecreasing places a subtree which fits the volume with place. Other subtrees
+// are separated by SeparateFunc, the parts are packed in the returned order
+// and then united back. Trees under the virtual super-root of a forest are packed
+// as independent items, the super-root itself is never placed into a bin.
+// Separated parts wait on an explicit stack, so deep trees don't grow the goroutine stack.
+func packDecreasing(pNode *tree.PartitionNode, bins []*Bin,
+	place func(*tree.PartitionNode, []*Bin) []*Bin) []*Bin {
+
+	type frame struct {
+		pNode    *tree.PartitionNode
+		separate []*tree.PartitionNode
+		forUnite []*tree.PartitionNode
+		next     int // index of the next part to pack
+	}
+	var stack []*frame
+	var pack = func(pNode *tree.PartitionNode) {
+		switch {
+		case pNode.Virtual:
+			var trees = append([]*tree.PartitionNode(nil), pNode.Children...)
+			sortDecreasing(trees)
+			stack = append(stack, &frame{pNode: pNode, separate: trees})
+		case fitsVolume(pNode):
+			bins = place(pNode, bins)
+		default:
+			var separate, forUnite = SeparateFunc(pNode)
+			stack = append(stack, &frame{pNode: pNode, separate: separate, forUnite: forUnite})
+		}
+	}
 
-		for _, node := range separate {
-			bins = HierarchicalGreedyDecreasing(node, bins)
+	pack(pNode)
+	for len(stack) > 0 {
+		var top = stack[len(stack)-1]
+		if top.next < len(top.separate) {
+			top.next++
+			pack(top.separate[top.next-1])
+			continue
 		}
 
-		Unite(pNode, forUnite)
+		stack = stack[:len(stack)-1]
+		Unite(top.pNode, top.forUnite)
 	}
 
 	return bins
@@ -149,7 +222,7 @@ func HierarchicalGreedyDecreasing(pNode *tree.PartitionNode, bins []*Bin) []*Bin
 
 func findBinForFit(bins []*Bin, pNode *tree.PartitionNode) *Bin {
 	for _, bin := range bins {
-		if pNode.SubTreeSize <= Volume-bin.Size {
+		if fitsBin(pNode, bin) {
 			return bin
 		}
 	}
@@ -157,24 +230,40 @@ func findBinForFit(bins []*Bin, pNode *tree.PartitionNode) *Bin {
 	return nil
 }
 
-func FindBinForRebalancing(bins []*Bin, tasksPerEpoch []map[string]int64,
-	nameToPartNode map[string]*tree.PartitionNode) *Bin {
+// FindBinForRebalancing consumes epochs until some bin gets overloaded or underloaded.
+// Bin sizes are sums over the window, which is moved forward by one epoch each step.
+// It returns nil bin when the source is exhausted.
+// Topology events are applied before the weights of their epoch, events may be nil.
+func FindBinForRebalancing(bins []*Bin, epochs hierarchy.EpochSource, window *hierarchy.EpochWindow,
+	events *hierarchy.EventQueue, nameToPartNode map[string]*tree.PartitionNode) (*Bin, error) {
 
 	var initiallyUnderloadedBins = make(map[*Bin]bool)
 	for _, bin := range bins {
-		initiallyUnderloadedBins[bin] = bin.Size <= UnderloadThreshold
+		initiallyUnderloadedBins[bin] = isUnderloaded(bin)
 	}
 
 	var loadedBin *Bin
-	for i := InitEpochs; i < len(tasksPerEpoch) && loadedBin == nil; i++ {
+	for loadedBin == nil {
+		var epoch, err = epochs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = ApplyTopologyEvents(bins, events.PopUntil(epoch.Number), nameToPartNode); err != nil {
+			return nil, err
+		}
+		window.Push(epoch)
 
-		updateSizeInOneTimeInterval(bins, tasksPerEpoch[i], nameToPartNode, true)             //Add
-		updateSizeInOneTimeInterval(bins, tasksPerEpoch[i-InitEpochs], nameToPartNode, false) //Sub
+		if err = updateLoads(bins, epoch, window.Deltas(), nameToPartNode); err != nil {
+			return nil, err
+		}
 
 		loadedBin = findOverOrUnderloadedBin(bins, initiallyUnderloadedBins)
 	}
 
-	return loadedBin
+	return loadedBin, nil
 }
 
 func DynamicalAlgorithmPackingFunc(loadedBin *Bin, bins []*Bin) ([]*Bin, int64) {
@@ -183,9 +272,7 @@ func DynamicalAlgorithmPackingFunc(loadedBin *Bin, bins []*Bin) ([]*Bin, int64)
 	var sliceRootNodesOfBin = loadedBin.MakeSliceRootNodesOfBin()
 	loadedBin.freeBin()
 
-	sort.Slice(sliceRootNodesOfBin, func(i, j int) bool {
-		return sliceRootNodesOfBin[i].SubTreeSize > sliceRootNodesOfBin[j].SubTreeSize
-	})
+	sortDecreasing(sliceRootNodesOfBin)
 
 	for _, rootNode := range sliceRootNodesOfBin {
 		PreprocessPartitionTree(rootNode) // think later
@@ -194,12 +281,15 @@ func DynamicalAlgorithmPackingFunc(loadedBin *Bin, bins []*Bin) ([]*Bin, int64)
 
 	tieChildNodesToOtherBins(untiedChildren)
 
-	//fmt.Println(oldSize, loadedBin.Size)
 	var migrationSize = oldSize - loadedBin.Size
 
 	return bins, migrationSize
 }
 
+// untieChildNodesOfBinFromOtherBins detaches children placed into other bins
+// from their parents in the bin, so subtrees of the bin can be repacked alone.
+// Detached children are roots until tieChildNodesToOtherBins, so the order
+// of detaching doesn't change sizes of the tree.
 func untieChildNodesOfBinFromOtherBins(bin *Bin) map[*tree.PartitionNode][]*tree.PartitionNode {
 	var untiedChildren = make(map[*tree.PartitionNode][]*tree.PartitionNode)
 
@@ -210,48 +300,104 @@ func untieChildNodesOfBinFromOtherBins(bin *Bin) map[*tree.PartitionNode][]*tree
 			}
 		}
 	}
-	var sum int64
 	for pNode, children := range untiedChildren {
 		for _, child := range children {
-			pNode.RemoveChild(child) // mistake!!!!!
-			sum += child.SubTreeSize
+			child.Detach()
+			debugCheck("untie", child)
 		}
+		debugCheck("untie", pNode)
 	}
-	fmt.Println(sum)
+
 	return untiedChildren
 }
 
 func tieChildNodesToOtherBins(untiedChildren map[*tree.PartitionNode][]*tree.PartitionNode) {
 	for pNode, children := range untiedChildren {
-		for _, child := range children {
-			pNode.AppendChild(child)
+		Unite(pNode, children)
+	}
+}
+
+// updateLoads adds changes of node loads to the nodes and their bins.
+// Weights of nodes missing in the tree are handled by UnknownNodes
+// when they come with the new epoch, other changes of such nodes are skipped.
+func updateLoads(bins []*Bin, epoch *hierarchy.Epoch, deltas []hierarchy.LoadDelta,
+	nameToPartNode map[string]*tree.PartitionNode) error {
+
+	if err := UnknownNodes.Check(epoch.Weights, nameToPartNode); err != nil {
+		return fmt.Errorf("packing : epoch %d: %v", epoch.Number, err)
+	}
+
+	for _, delta := range deltas {
+		var pNode = nameToPartNode[delta.Name]
+		var tasks, isNew = epoch.Weights[delta.Name]
+		if pNode == nil && isNew {
+			var created []*tree.PartitionNode
+			var err error
+			if pNode, created, err = UnknownNodes.Resolve(delta.Name, tasks, nameToPartNode); err != nil {
+				return fmt.Errorf("packing : epoch %d: %v", epoch.Number, err)
+			}
+			for _, node := range created {
+				if bin := findBinOfNewNode(bins, node); bin != nil {
+					bin.PartNodes[node] = true
+				}
+			}
+		}
+
+		if bin := findBinOfNode(bins, pNode); bin != nil {
+			bin.AddToBinSize(pNode, delta.Weight)
+			if delta.Extra != nil {
+				bin.AddToBinExtraSize(pNode, delta.Extra)
+			}
 		}
 	}
+
+	return nil
 }
 
-func updateSizeInOneTimeInterval(bins []*Bin, tasksPerOneEpoch map[string]int64,
-	nameToPartNode map[string]*tree.PartitionNode, isPlus bool) {
+func findBinOfNode(bins []*Bin, pNode *tree.PartitionNode) *Bin {
+	for _, bin := range bins {
+		if ok := bin.PartNodes[pNode]; ok {
+			return bin
+		}
+	}
+
+	return nil
+}
 
-	for name, tasks := range tasksPerOneEpoch { //Add
-		var pNode = nameToPartNode[name]
+// findBinOfNewNode returns the bin of the parent. A new tree under
+// the virtual super-root goes to the least loaded bin.
+func findBinOfNewNode(bins []*Bin, pNode *tree.PartitionNode) *Bin {
+	if pNode.Parent == nil || !pNode.Parent.Virtual {
+		return findBinOfNode(bins, pNode.Parent)
+	}
 
-		if !isPlus {
-			tasks = -tasks
+	var leastLoaded *Bin
+	for _, bin := range bins {
+		if leastLoaded == nil || bin.Size < leastLoaded.Size {
+			leastLoaded = bin
 		}
+	}
+	return leastLoaded
+}
 
-		for _, bin := range bins {
-			if ok := bin.PartNodes[pNode]; ok {
-				bin.AddToBinSize(pNode, tasks)
-				break
+// Splits counts nodes placed into another bin than their parent.
+// Trees under the virtual super-root are independent, so they are not splits.
+func Splits(bins []*Bin) int {
+	var splits int
+	for _, bin := range bins {
+		for pNode := range bin.PartNodes {
+			if pNode.Parent != nil && !pNode.Parent.Virtual && !bin.PartNodes[pNode.Parent] {
+				splits++
 			}
 		}
 	}
+
+	return splits
 }
 
 func findOverOrUnderloadedBin(bins []*Bin, initiallyUnderloadedBins map[*Bin]bool) *Bin {
 	for _, bin := range bins {
-		if (bin.Size >= OverloadThreshold) ||
-			(bin.Size <= UnderloadThreshold && !initiallyUnderloadedBins[bin]) {
+		if isOverloaded(bin) || (isUnderloaded(bin) && !initiallyUnderloadedBins[bin]) {
 			return bin
 		}
 	}
@@ -260,48 +406,62 @@ func findOverOrUnderloadedBin(bins []*Bin, initiallyUnderloadedBins map[*Bin]boo
 }
 
 func SeparateRoot(pNode *tree.PartitionNode) ([]*tree.PartitionNode, []*tree.PartitionNode) {
-	var separate = make([]*tree.PartitionNode, 0, len(pNode.Children)+1)
-	for _, ch := range pNode.Children {
-		separate = append(separate, ch)
+	if len(pNode.Children) == 0 {
+		unseparable("SeparateRoot", pNode.Name)
+	}
+	var forUnite = append([]*tree.PartitionNode(nil), pNode.Children...)
+	for _, child := range forUnite {
+		child.Detach()
+	}
+	var separate = make([]*tree.PartitionNode, 0, len(forUnite)+1)
+	separate = append(append(separate, forUnite...), pNode)
+	for _, part := range separate {
+		debugCheck("SeparateRoot", part)
 	}
-	var forUnite = pNode.Children
-	pNode.Children = nil
-	pNode.SubTreeSize = pNode.NodeSize
-	separate = append(separate, pNode)
 
-	sort.Slice(separate, func(i, j int) bool {
-		return separate[i].SubTreeSize > separate[j].SubTreeSize
-	})
+	sortDecreasing(separate)
 
 	return separate, forUnite
 }
 
+// sortDecreasing sorts subtrees by size, ties keep their order
+// unless Rand shuffles them first.
+func sortDecreasing(pNodes []*tree.PartitionNode) {
+	if Rand != nil {
+		Rand.Shuffle(len(pNodes), func(i, j int) { pNodes[i], pNodes[j] = pNodes[j], pNodes[i] })
+	}
+	sort.SliceStable(pNodes, func(i, j int) bool {
+		return subTreeOrder(pNodes[i]) > subTreeOrder(pNodes[j])
+	})
+}
+
 func SeparateMaxChild(pNode *tree.PartitionNode) ([]*tree.PartitionNode, []*tree.PartitionNode) {
 	var maxChild *tree.PartitionNode
-	var maxSize int64 = 0
+	var maxSize float64 = 0
+	var ties int
 	for _, child := range pNode.Children {
-		if child.SubTreeSize >= maxSize {
-			maxSize = child.SubTreeSize
-			maxChild = child
+		var order = subTreeOrder(child)
+		switch {
+		case maxChild == nil || order > maxSize:
+			maxChild, maxSize, ties = child, order, 1
+		case order == maxSize:
+			ties++
+			if Rand == nil || Rand.Intn(ties) == 0 { // the last child wins unless Rand picks one
+				maxChild = child
+			}
 		}
 	}
 
 	if maxChild == nil {
-		fmt.Println("something wrong")
-		return nil, nil
+		unseparable("SeparateMaxChild", pNode.Name)
 	}
 
-	// pNode.RemoveChild(maxChild)  ?? Debug
-	for idx := range pNode.Children { // Debug
-		if pNode.Children[idx] == maxChild {
-			pNode.Children = append(pNode.Children[:idx], pNode.Children[idx+1:]...) // mistake
-			break
-		}
-	}
-	pNode.SubTreeSize -= maxChild.SubTreeSize
+	maxChild.Detach()
+	debugCheck("SeparateMaxChild", pNode)
+	debugCheck("SeparateMaxChild", maxChild)
 
 	var separate = make([]*tree.PartitionNode, 0)
-	if maxChild.SubTreeSize > pNode.SubTreeSize {
+	if subTreeOrder(maxChild) > subTreeOrder(pNode) {
 		separate = append(separate, maxChild, pNode)
 	} else {
 		separate = append(separate, pNode, maxChild)
@@ -312,9 +472,19 @@ func SeparateMaxChild(pNode *tree.PartitionNode) ([]*tree.PartitionNode, []*tree
 	return separate, forUnite
 }
 
+// Unite reattaches children separated from the node.
 func Unite(pNode *tree.PartitionNode, children []*tree.PartitionNode) {
 	for _, child := range children {
-		pNode.Children = append(pNode.Children, child)
-		pNode.SubTreeSize += child.SubTreeSize
+		if err := child.Reattach(pNode); err != nil {
+			panic(err) // separated children are detached and can't be ancestors of the node
+		}
+	}
+	debugCheck("Unite", pNode)
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
 	}
+	return b
 }
@@ -0,0 +1,86 @@
+package packing
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+func TestPackMillionDeepChain(t *testing.T) {
+	const depth = 1000000
+	var childToParent = map[string]string{"n0": "n0"}
+	var weights = map[string]int64{"n0": 1}
+	for idx := 1; idx < depth; idx++ {
+		childToParent["n"+strconv.Itoa(idx)] = "n" + strconv.Itoa(idx-1)
+		weights["n"+strconv.Itoa(idx)] = 1
+	}
+	var root, err = tree.NewTree(childToParent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pRoot = tree.NewPartitionTree(root)
+	if err = pRoot.SetInitialSize([]map[string]int64{weights}, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	MaxCapacity = 1000
+	UpdParams()
+	SeparateFunc = SeparateRoot
+	PreprocessPartitionTree(pRoot)
+	var bins = HierarchicalFirstFitDecreasing(pRoot, nil)
+
+	var total int64
+	for _, bin := range bins {
+		if bin.Size > Volume {
+			t.Errorf("bin %d of size %d, volume %d", bin.Index, bin.Size, Volume)
+		}
+		total += bin.Size
+	}
+	if total != depth {
+		t.Errorf("bins hold %d, want %d", total, depth)
+	}
+	if err = tree.Check(pRoot).Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSeparateMaxChildTies(t *testing.T) {
+	defer func() { Rand = nil }()
+	var separated = make(map[string]bool)
+	for seed := int64(0); seed < 20; seed++ {
+		var root, _ = tree.NewTree(map[string]string{"r": "r", "a": "r", "b": "r", "c": "r"})
+		var pRoot = tree.NewPartitionTree(root)
+		_ = pRoot.SetInitialSize([]map[string]int64{{"r": 1, "a": 5, "b": 5, "c": 5}}, 1, nil)
+
+		Rand = nil
+		if seed > 0 {
+			Rand = rand.New(rand.NewSource(seed))
+		}
+		var _, forUnite = SeparateMaxChild(pRoot)
+		if seed == 0 && forUnite[0].Name != "c" {
+			t.Errorf("without a seed %q is separated, want the last child", forUnite[0].Name)
+		}
+		separated[forUnite[0].Name] = true
+		Unite(pRoot, forUnite)
+	}
+	if len(separated) != 3 {
+		t.Errorf("seeds separate only %v", separated)
+	}
+}
+
+func TestSeparateLeafPanics(t *testing.T) {
+	for name, separate := range map[string]func(*tree.PartitionNode) ([]*tree.PartitionNode, []*tree.PartitionNode){
+		"SeparateRoot": SeparateRoot, "SeparateMaxChild": SeparateMaxChild,
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of a leaf didn't panic", name)
+				}
+			}()
+			separate(&tree.PartitionNode{Name: "leaf", NodeSize: 10, SubTreeSize: 10})
+		}()
+	}
+}
@@ -0,0 +1,148 @@
+package packing
+
+import (
+	"github.com/dati-mipt/dhsbpp/tree"
+	"math"
+)
+
+// Resource holds capacity and thresholds of an additional resource.
+// The first resource is configured by MaxCapacity, AllocationFactor and ReallocationDelta.
+type Resource struct {
+	Name              string
+	MaxCapacity       int64
+	AllocationFactor  int64
+	ReallocationDelta int64
+
+	Volume             int64
+	OverloadThreshold  int64
+	UnderloadThreshold int64
+}
+
+var ExtraResources []Resource // empty for a single resource
+
+// SizeOrderFunc maps sizes of a subtree to the key of decreasing ordering.
+var SizeOrderFunc func(size int64, extra []int64) float64 = OrderBySize
+
+// OrderBySize uses the first resource only.
+func OrderBySize(size int64, extra []int64) float64 {
+	return float64(size)
+}
+
+// OrderByMaxDimension uses the largest size of all resources.
+func OrderByMaxDimension(size int64, extra []int64) float64 {
+	var maxSize = size
+	for _, value := range extra {
+		if value > maxSize {
+			maxSize = value
+		}
+	}
+
+	return float64(maxSize)
+}
+
+// OrderByL2Norm uses the euclidean norm of sizes relative to volumes.
+func OrderByL2Norm(size int64, extra []int64) float64 {
+	var sum = square(relative(size, Volume))
+	for idx, resource := range ExtraResources {
+		sum += square(relative(extraAt(extra, idx), resource.Volume))
+	}
+
+	return math.Sqrt(sum)
+}
+
+// OrderByDominantResource uses the largest size relative to volume.
+func OrderByDominantResource(size int64, extra []int64) float64 {
+	var dominant = relative(size, Volume)
+	for idx, resource := range ExtraResources {
+		dominant = math.Max(dominant, relative(extraAt(extra, idx), resource.Volume))
+	}
+
+	return dominant
+}
+
+func subTreeOrder(pNode *tree.PartitionNode) float64 {
+	return SizeOrderFunc(pNode.SubTreeSize, pNode.ExtraSubTreeSize)
+}
+
+func updResourceParams() {
+	for idx := range ExtraResources {
+		var r = &ExtraResources[idx]
+		r.Volume = r.MaxCapacity * r.AllocationFactor / 100
+		r.OverloadThreshold = r.MaxCapacity * (r.AllocationFactor + r.ReallocationDelta) / 100
+		r.UnderloadThreshold = r.MaxCapacity * (r.AllocationFactor - r.ReallocationDelta) / 100
+	}
+}
+
+// return true if the subtree fits into an empty bin
+func fitsVolume(pNode *tree.PartitionNode) bool {
+	if pNode.SubTreeSize > Volume {
+		return false
+	}
+	for idx, resource := range ExtraResources {
+		if extraAt(pNode.ExtraSubTreeSize, idx) > resource.Volume {
+			return false
+		}
+	}
+
+	return true
+}
+
+// return true if the subtree fits into the free space of the bin
+func fitsBin(pNode *tree.PartitionNode, bin *Bin) bool {
+	if pNode.SubTreeSize > Volume-bin.Size {
+		return false
+	}
+	for idx, resource := range ExtraResources {
+		if extraAt(pNode.ExtraSubTreeSize, idx) > resource.Volume-extraAt(bin.ExtraSize, idx) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// a bin is overloaded if any of resources is overloaded
+func isOverloaded(bin *Bin) bool {
+	if bin.Size >= OverloadThreshold {
+		return true
+	}
+	for idx, resource := range ExtraResources {
+		if extraAt(bin.ExtraSize, idx) >= resource.OverloadThreshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a bin is underloaded if all resources are underloaded
+func isUnderloaded(bin *Bin) bool {
+	if bin.Size > UnderloadThreshold {
+		return false
+	}
+	for idx, resource := range ExtraResources {
+		if extraAt(bin.ExtraSize, idx) > resource.UnderloadThreshold {
+			return false
+		}
+	}
+
+	return true
+}
+
+func extraAt(extra []int64, idx int) int64 {
+	if idx < len(extra) {
+		return extra[idx]
+	}
+	return 0
+}
+
+func relative(size int64, volume int64) float64 {
+	if volume == 0 {
+		return 0
+	}
+	return float64(size) / float64(volume)
+}
+
+func square(x float64) float64 {
+	return x * x
+}
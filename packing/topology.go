@@ -0,0 +1,86 @@
+package packing
+
+import (
+	"fmt"
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// ApplyTopologyEvents changes the partition tree and the bins.
+// A new node is placed into the bin of its parent, the space of
+// a deleted node is freed in its bin. Moved nodes stay in their bins.
+func ApplyTopologyEvents(bins []*Bin, events []hierarchy.TopologyEvent,
+	nameToPartNode map[string]*tree.PartitionNode) error {
+
+	for _, event := range events {
+		if err := applyTopologyEvent(bins, event, nameToPartNode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyTopologyEvent(bins []*Bin, event hierarchy.TopologyEvent,
+	nameToPartNode map[string]*tree.PartitionNode) error {
+
+	var pNode, parent = nameToPartNode[event.Node], nameToPartNode[event.Parent]
+	if pNode == nil && event.Kind != hierarchy.AddNode {
+		return fmt.Errorf("packing : epoch %d: %v of unknown node %q", event.Epoch, event.Kind, event.Node)
+	}
+	if parent == nil && event.Kind != hierarchy.DeleteNode {
+		return fmt.Errorf("packing : epoch %d: %v under unknown node %q", event.Epoch, event.Kind, event.Parent)
+	}
+
+	switch event.Kind {
+	case hierarchy.AddNode:
+		if pNode != nil {
+			return fmt.Errorf("packing : epoch %d: node %q already exists", event.Epoch, event.Node)
+		}
+
+		pNode = parent.AddNode(event.Node)
+		nameToPartNode[event.Node] = pNode
+		if bin := findBinOfNewNode(bins, pNode); bin != nil {
+			bin.PartNodes[pNode] = true
+		}
+		debugCheck("add", pNode)
+
+	case hierarchy.DeleteNode:
+		return deleteNode(bins, pNode, nameToPartNode)
+
+	case hierarchy.MoveNode:
+		if err := tree.MoveSubtree(pNode, parent); err != nil {
+			return fmt.Errorf("packing : epoch %d: %v", event.Epoch, err)
+		}
+		debugCheck("move", pNode)
+	}
+
+	return nil
+}
+
+// deleteNode deletes the node together with the chunks
+// created for it by PreprocessPartitionTree.
+func deleteNode(bins []*Bin, pNode *tree.PartitionNode, nameToPartNode map[string]*tree.PartitionNode) error {
+	for _, child := range pNode.Children {
+		if child.Name == pNode.Name+"#" {
+			if err := deleteNode(bins, child, nameToPartNode); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	if bin := findBinOfNode(bins, pNode); bin != nil {
+		bin.Size -= pNode.NodeSize
+		tree.AddVector(&bin.ExtraSize, pNode.ExtraNodeSize, -1)
+		delete(bin.PartNodes, pNode)
+	}
+	var parent = pNode.Parent
+	if err := pNode.Delete(); err != nil {
+		return err
+	}
+	delete(nameToPartNode, pNode.Name)
+	debugCheck("delete", parent)
+
+	return nil
+}
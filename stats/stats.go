@@ -0,0 +1,277 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+type Options struct {
+	Top         int   // number of heaviest and most volatile nodes to report
+	MaxCapacity int64 // bin capacity for the lower bound, 0 disables it
+	AF          int64 // allocation factor in percent
+	Window      int   // number of epochs summed into node weight, as init_epochs
+}
+
+type NodeWeight struct {
+	Name   string `json:"name"`
+	Weight int64  `json:"weight"`
+}
+
+type NodeVolatility struct {
+	Name string  `json:"name"`
+	Mean float64 `json:"mean"`
+	Std  float64 `json:"std"`
+	CV   float64 `json:"cv"` // coefficient of variation, std / mean
+}
+
+type Report struct {
+	Nodes        int `json:"nodes"`
+	Leaves       int `json:"leaves"`
+	MaxDepth     int `json:"max_depth"`
+	UnknownNodes int `json:"unknown_nodes"` // nodes having weights but missing in the tree
+
+	DepthHistogram  map[int]int `json:"depth_histogram"`   // depth -> number of nodes
+	FanOutHistogram map[int]int `json:"fan_out_histogram"` // number of children -> number of nodes
+
+	HeaviestNodes    []NodeWeight     `json:"heaviest_nodes"`
+	HeaviestSubtrees []NodeWeight     `json:"heaviest_subtrees"`
+	MostVolatile     []NodeVolatility `json:"most_volatile"`
+
+	Epochs           hierarchy.EpochRange `json:"-"`
+	FirstEpoch       int                  `json:"first_epoch"` // number of the epoch WeightPerEpoch starts with
+	WeightPerEpoch   []int64              `json:"weight_per_epoch"`
+	TotalWeight      int64                `json:"total_weight"`
+	Volume           int64                `json:"volume,omitempty"`
+	InitialBinsBound int64                `json:"initial_bins_lower_bound,omitempty"` // for the first window
+	PeakBinsBound    int64                `json:"peak_bins_lower_bound,omitempty"`    // for the heaviest window
+}
+
+// Compute collects statistics of the tree and weights of the hierarchy.
+func Compute(h *hierarchy.Hierarchy, root *tree.Node, opts Options) (*Report, error) {
+	var allNodes, err = root.AllNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var report = &Report{Nodes: len(allNodes), Epochs: h.Range, FirstEpoch: h.Range.First,
+		DepthHistogram: make(map[int]int), FanOutHistogram: make(map[int]int)}
+	root.PreOrder(func(node *tree.Node, depth int) bool {
+		report.addShape(node, depth)
+		return true
+	})
+
+	var nameToNode = make(map[string]*tree.Node, len(allNodes))
+	for _, node := range allNodes {
+		nameToNode[node.Name] = node
+	}
+
+	var totalPerNode = make(map[string]int64)
+	var unknown = make(map[string]bool)
+	report.WeightPerEpoch = make([]int64, len(h.WeightsPerEpoch))
+	for idx, weights := range h.WeightsPerEpoch {
+		for name, weight := range weights {
+			report.WeightPerEpoch[idx] += weight
+			totalPerNode[name] += weight
+			if _, ok := nameToNode[name]; !ok {
+				unknown[name] = true
+			}
+		}
+		report.TotalWeight += report.WeightPerEpoch[idx]
+	}
+	report.UnknownNodes = len(unknown)
+
+	var subtreePerNode = make(map[string]int64)
+	subtreeWeight(root, totalPerNode, subtreePerNode)
+	delete(subtreePerNode, root.Name) // the root subtree is the whole tree
+
+	report.HeaviestNodes = heaviest(totalPerNode, opts.Top)
+	report.HeaviestSubtrees = heaviest(subtreePerNode, opts.Top)
+	report.MostVolatile = mostVolatile(h.WeightsPerEpoch, totalPerNode, opts.Top)
+
+	if opts.MaxCapacity > 0 {
+		report.Volume = opts.MaxCapacity * opts.AF / 100
+		report.InitialBinsBound, report.PeakBinsBound = binsLowerBound(report.WeightPerEpoch,
+			opts.Window, report.Volume)
+	}
+
+	return report, nil
+}
+
+func (report *Report) addShape(node *tree.Node, depth int) {
+	report.DepthHistogram[depth]++
+	report.FanOutHistogram[len(node.Children)]++
+	if len(node.Children) == 0 {
+		report.Leaves++
+	}
+	if depth > report.MaxDepth {
+		report.MaxDepth = depth
+	}
+}
+
+// subtreeWeight sums weights of every subtree, children are summed before their parents.
+func subtreeWeight(root *tree.Node, totalPerNode map[string]int64, subtreePerNode map[string]int64) {
+	root.PostOrder(func(node *tree.Node) {
+		var weight = totalPerNode[node.Name]
+		for _, child := range node.Children {
+			weight += subtreePerNode[child.Name]
+		}
+		subtreePerNode[node.Name] = weight
+	})
+}
+
+func heaviest(weights map[string]int64, top int) []NodeWeight {
+	var nodes = make([]NodeWeight, 0, len(weights))
+	for name, weight := range weights {
+		nodes = append(nodes, NodeWeight{Name: name, Weight: weight})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Weight != nodes[j].Weight {
+			return nodes[i].Weight > nodes[j].Weight
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	if len(nodes) > top {
+		nodes = nodes[:top]
+	}
+	return nodes
+}
+
+func mostVolatile(weightsPerEpoch []map[string]int64, totalPerNode map[string]int64, top int) []NodeVolatility {
+	if len(weightsPerEpoch) == 0 {
+		return nil
+	}
+
+	var epochs = float64(len(weightsPerEpoch))
+	var nodes = make([]NodeVolatility, 0, len(totalPerNode))
+	for name, total := range totalPerNode {
+		var mean = float64(total) / epochs
+		var variance float64
+		for _, weights := range weightsPerEpoch {
+			variance += (float64(weights[name]) - mean) * (float64(weights[name]) - mean)
+		}
+		variance /= epochs
+
+		var volatility = NodeVolatility{Name: name, Mean: mean, Std: math.Sqrt(variance)}
+		if mean != 0 {
+			volatility.CV = volatility.Std / math.Abs(mean)
+		}
+		nodes = append(nodes, volatility)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].CV != nodes[j].CV {
+			return nodes[i].CV > nodes[j].CV
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	if len(nodes) > top {
+		nodes = nodes[:top]
+	}
+	return nodes
+}
+
+// binsLowerBound returns ceil(weight / volume) for the first window of epochs
+// and for the heaviest one. No packing can use fewer bins.
+func binsLowerBound(weightPerEpoch []int64, window int, volume int64) (int64, int64) {
+	if window <= 0 {
+		window = 1
+	}
+	if volume <= 0 {
+		return 0, 0
+	}
+
+	var sum, initial, peak int64
+	for idx, weight := range weightPerEpoch {
+		sum += weight
+		if idx >= window {
+			sum -= weightPerEpoch[idx-window]
+		}
+		if idx == window-1 || (idx == len(weightPerEpoch)-1 && idx < window) {
+			initial = sum
+		}
+		if sum > peak {
+			peak = sum
+		}
+	}
+
+	return (initial + volume - 1) / volume, (peak + volume - 1) / volume
+}
+
+func (report *Report) WriteJSON(w io.Writer) error {
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(report)
+}
+
+func (report *Report) WriteText(w io.Writer) error {
+	var p = &printer{w: w}
+
+	p.printf("Nodes: %d (%d leaves)\n", report.Nodes, report.Leaves)
+	p.printf("Max depth: %d\n", report.MaxDepth)
+	if report.UnknownNodes > 0 {
+		p.printf("Nodes with weights missing in the tree: %d\n", report.UnknownNodes)
+	}
+
+	p.printf("\nDepth distribution:\n")
+	p.histogram(report.DepthHistogram, "depth")
+	p.printf("\nFan-out histogram:\n")
+	p.histogram(report.FanOutHistogram, "children")
+
+	p.printf("\nHeaviest nodes:\n")
+	for _, node := range report.HeaviestNodes {
+		p.printf("  %-20s %d\n", node.Name, node.Weight)
+	}
+	p.printf("\nHeaviest subtrees:\n")
+	for _, node := range report.HeaviestSubtrees {
+		p.printf("  %-20s %d\n", node.Name, node.Weight)
+	}
+	p.printf("\nMost volatile nodes:\n")
+	for _, node := range report.MostVolatile {
+		p.printf("  %-20s mean %.1f, std %.1f, cv %.2f\n", node.Name, node.Mean, node.Std, node.CV)
+	}
+
+	p.printf("\nWeight per epoch (%v):\n", report.Epochs)
+	for idx, weight := range report.WeightPerEpoch {
+		p.printf("  %6d %d\n", report.Epochs.First+idx, weight)
+	}
+	p.printf("Total weight: %d\n", report.TotalWeight)
+
+	if report.Volume > 0 {
+		p.printf("\nBin volume: %d\n", report.Volume)
+		p.printf("Lower bound on bins: %d initially, %d at peak\n", report.InitialBinsBound, report.PeakBinsBound)
+	}
+
+	return p.err
+}
+
+// printer remembers the first write error
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *printer) histogram(histogram map[int]int, unit string) {
+	var keys = make([]int, 0, len(histogram))
+	for key := range histogram {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	for _, key := range keys {
+		p.printf("  %6d %s: %d\n", key, unit, histogram[key])
+	}
+}
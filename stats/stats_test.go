@@ -0,0 +1,126 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/dati-mipt/dhsbpp/hierarchy"
+	"github.com/dati-mipt/dhsbpp/tree"
+)
+
+// r -> a -> c, r -> b, x has weights but no node
+func testReport(t *testing.T) *Report {
+	t.Helper()
+	var root, err = tree.NewTree(map[string]string{"r": "r", "a": "r", "b": "r", "c": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h = &hierarchy.Hierarchy{
+		WeightsPerEpoch: []map[string]int64{{"a": 2, "c": 4}, {"b": 6, "c": 2, "x": 1}},
+		Range:           hierarchy.EpochRange{First: 5, Last: 6},
+	}
+
+	report, err := Compute(h, root, Options{Top: 2, MaxCapacity: 10, AF: 60, Window: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestWriteText(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReport(t).WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	const expected = `Nodes: 4 (2 leaves)
+Max depth: 2
+Nodes with weights missing in the tree: 1
+
+Depth distribution:
+       0 depth: 1
+       1 depth: 2
+       2 depth: 1
+
+Fan-out histogram:
+       0 children: 2
+       1 children: 1
+       2 children: 1
+
+Heaviest nodes:
+  b                    6
+  c                    6
+
+Heaviest subtrees:
+  a                    8
+  b                    6
+
+Most volatile nodes:
+  a                    mean 1.0, std 1.0, cv 1.00
+  b                    mean 3.0, std 3.0, cv 1.00
+
+Weight per epoch (epochs 5..6 (2 epochs)):
+       5 6
+       6 9
+Total weight: 15
+
+Bin volume: 6
+Lower bound on bins: 1 initially, 2 at peak
+`
+	if out.String() != expected {
+		t.Errorf("text report:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var report = testReport(t)
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]float64{"nodes": 4, "first_epoch": 5, "total_weight": 15,
+		"volume": 6, "initial_bins_lower_bound": 1, "peak_bins_lower_bound": 2} {
+		if fields[key] != value {
+			t.Errorf("%s is %v, expected %v", key, fields[key], value)
+		}
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	report.Epochs = hierarchy.EpochRange{} // not encoded
+	if !reflect.DeepEqual(&decoded, report) {
+		t.Errorf("decoded report %+v, expected %+v", decoded, *report)
+	}
+}
+
+func TestBinsLowerBound(t *testing.T) {
+	for _, test := range []struct {
+		weights       []int64
+		window        int
+		volume        int64
+		initial, peak int64
+	}{
+		{[]int64{6, 9}, 1, 6, 1, 2},
+		{[]int64{6, 9}, 2, 6, 3, 3},
+		{[]int64{6, 9}, 5, 6, 3, 3},         // window longer than the epochs
+		{[]int64{12, 0, 1, 13}, 2, 6, 2, 3}, // windows 12, 1, 14
+		{[]int64{5, 7}, 0, 6, 1, 2},         // window 0 counts as 1
+		{[]int64{5, 7}, 1, 0, 0, 0},         // no volume
+		{nil, 3, 6, 0, 0},
+	} {
+		var initial, peak = binsLowerBound(test.weights, test.window, test.volume)
+		if initial != test.initial || peak != test.peak {
+			t.Errorf("weights %v, window %d, volume %d: bounds %d and %d, expected %d and %d",
+				test.weights, test.window, test.volume, initial, peak, test.initial, test.peak)
+		}
+	}
+}
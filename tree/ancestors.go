@@ -0,0 +1,253 @@
+package tree
+
+import "math/bits"
+
+// Depth returns the number of edges from the root to the node.
+func (node *Node) Depth() int {
+	var depth int
+	for ptr := node.Parent; ptr != nil; ptr = ptr.Parent {
+		depth++
+	}
+	return depth
+}
+
+// PathToRoot returns the node, its parent and so on up to the root.
+func (node *Node) PathToRoot() []*Node {
+	var path []*Node
+	for ptr := node; ptr != nil; ptr = ptr.Parent {
+		path = append(path, ptr)
+	}
+	return path
+}
+
+// IsAncestor reports whether the node is a proper ancestor of the other node.
+func (node *Node) IsAncestor(other *Node) bool {
+	for ptr := other.Parent; ptr != nil; ptr = ptr.Parent {
+		if ptr == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Depth returns the number of edges from the root to the node.
+func (pNode *PartitionNode) Depth() int {
+	var depth int
+	for ptr := pNode.Parent; ptr != nil; ptr = ptr.Parent {
+		depth++
+	}
+	return depth
+}
+
+// PathToRoot returns the node, its parent and so on up to the root.
+func (pNode *PartitionNode) PathToRoot() []*PartitionNode {
+	var path []*PartitionNode
+	for ptr := pNode; ptr != nil; ptr = ptr.Parent {
+		path = append(path, ptr)
+	}
+	return path
+}
+
+// IsAncestor reports whether the node is a proper ancestor of the other node.
+func (pNode *PartitionNode) IsAncestor(other *PartitionNode) bool {
+	for ptr := other.Parent; ptr != nil; ptr = ptr.Parent {
+		if ptr == pNode {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------LCA Index----------------------
+
+// lifting answers ancestor queries on pre-order indexes of nodes: the subtree
+// of node idx is [idx, end[idx]), so IsAncestor takes O(1), and up[k][idx] is
+// the ancestor 2^k levels above (the root above the root), so LCA takes O(log depth).
+type lifting struct {
+	depth []int32
+	end   []int32
+	up    [][]int32
+}
+
+// newLifting builds the index of a tree given by parents of nodes in pre-order, -1 for the root
+func newLifting(parents []int32) lifting {
+	var l = lifting{depth: make([]int32, len(parents)), end: make([]int32, len(parents))}
+	var parent = make([]int32, len(parents))
+	var maxDepth int32
+	for idx, p := range parents {
+		l.end[idx] = int32(idx + 1)
+		if p < 0 {
+			parent[idx] = int32(idx)
+			continue
+		}
+		parent[idx] = p
+		l.depth[idx] = l.depth[p] + 1
+		if l.depth[idx] > maxDepth {
+			maxDepth = l.depth[idx]
+		}
+	}
+	for idx := len(parents) - 1; idx > 0; idx-- {
+		if p := parents[idx]; p >= 0 && l.end[idx] > l.end[p] {
+			l.end[p] = l.end[idx]
+		}
+	}
+
+	l.up = append(l.up, parent)
+	for k := 1; k < bits.Len32(uint32(maxDepth)); k++ {
+		var prev, next = l.up[k-1], make([]int32, len(parents))
+		for idx := range next {
+			next[idx] = prev[prev[idx]]
+		}
+		l.up = append(l.up, next)
+	}
+
+	return l
+}
+
+// isAncestorOrSelf reports whether a is b or an ancestor of b
+func (l *lifting) isAncestorOrSelf(a int32, b int32) bool {
+	return a <= b && b < l.end[a]
+}
+
+func (l *lifting) lca(a int32, b int32) int32 {
+	if l.isAncestorOrSelf(a, b) {
+		return a
+	}
+	if l.isAncestorOrSelf(b, a) {
+		return b
+	}
+	for k := len(l.up) - 1; k >= 0; k-- {
+		if next := l.up[k][a]; !l.isAncestorOrSelf(next, b) {
+			a = next
+		}
+	}
+	return l.up[0][a]
+}
+
+func (l *lifting) distance(a int32, b int32) int {
+	return int(l.depth[a] + l.depth[b] - 2*l.depth[l.lca(a, b)])
+}
+
+// LCAIndex answers depth, ancestor and lowest common ancestor queries on a tree
+// in O(1) or O(log depth) after O(n log depth) preprocessing. It is a snapshot:
+// it must be rebuilt after the tree changes. Queries about nodes which are not
+// in the tree return -1, false or nil. Trees of a forest meet at the virtual super-root.
+type LCAIndex struct {
+	lifting
+	nodes []*Node
+	index map[*Node]int32
+}
+
+func NewLCAIndex(root *Node) *LCAIndex {
+	var x = &LCAIndex{index: make(map[*Node]int32)}
+	var parents []int32
+	root.PreOrder(func(node *Node, _ int) bool {
+		var parent = int32(-1)
+		if node != root {
+			parent = x.index[node.Parent]
+		}
+		x.index[node] = int32(len(x.nodes))
+		x.nodes = append(x.nodes, node)
+		parents = append(parents, parent)
+		return true
+	})
+	x.lifting = newLifting(parents)
+
+	return x
+}
+
+// Depth returns the number of edges from the root of the index to the node.
+func (x *LCAIndex) Depth(node *Node) int {
+	if idx, ok := x.index[node]; ok {
+		return int(x.depth[idx])
+	}
+	return -1
+}
+
+// IsAncestor reports whether a is a proper ancestor of b.
+func (x *LCAIndex) IsAncestor(a *Node, b *Node) bool {
+	var aIdx, aOk = x.index[a]
+	var bIdx, bOk = x.index[b]
+	return aOk && bOk && aIdx != bIdx && x.isAncestorOrSelf(aIdx, bIdx)
+}
+
+// LCA returns the deepest node which is an ancestor of both nodes or one of them.
+func (x *LCAIndex) LCA(a *Node, b *Node) *Node {
+	var aIdx, aOk = x.index[a]
+	var bIdx, bOk = x.index[b]
+	if !aOk || !bOk {
+		return nil
+	}
+	return x.nodes[x.lca(aIdx, bIdx)]
+}
+
+// Distance returns the number of edges on the path between the nodes.
+func (x *LCAIndex) Distance(a *Node, b *Node) int {
+	var aIdx, aOk = x.index[a]
+	var bIdx, bOk = x.index[b]
+	if !aOk || !bOk {
+		return -1
+	}
+	return x.distance(aIdx, bIdx)
+}
+
+// PartitionLCAIndex is LCAIndex for a partition tree, e.g. to measure how far apart
+// the subtrees placed into one bin are. Chunks are ordinary nodes of the index.
+type PartitionLCAIndex struct {
+	lifting
+	nodes []*PartitionNode
+	index map[*PartitionNode]int32
+}
+
+func NewPartitionLCAIndex(pRoot *PartitionNode) *PartitionLCAIndex {
+	var x = &PartitionLCAIndex{index: make(map[*PartitionNode]int32)}
+	var parents []int32
+	pRoot.PreOrder(func(pNode *PartitionNode, _ int) bool {
+		var parent = int32(-1)
+		if pNode != pRoot {
+			parent = x.index[pNode.Parent]
+		}
+		x.index[pNode] = int32(len(x.nodes))
+		x.nodes = append(x.nodes, pNode)
+		parents = append(parents, parent)
+		return true
+	})
+	x.lifting = newLifting(parents)
+
+	return x
+}
+
+// Depth returns the number of edges from the root of the index to the node.
+func (x *PartitionLCAIndex) Depth(pNode *PartitionNode) int {
+	if idx, ok := x.index[pNode]; ok {
+		return int(x.depth[idx])
+	}
+	return -1
+}
+
+// IsAncestor reports whether a is a proper ancestor of b.
+func (x *PartitionLCAIndex) IsAncestor(a *PartitionNode, b *PartitionNode) bool {
+	var aIdx, aOk = x.index[a]
+	var bIdx, bOk = x.index[b]
+	return aOk && bOk && aIdx != bIdx && x.isAncestorOrSelf(aIdx, bIdx)
+}
+
+// LCA returns the deepest node which is an ancestor of both nodes or one of them.
+func (x *PartitionLCAIndex) LCA(a *PartitionNode, b *PartitionNode) *PartitionNode {
+	var aIdx, aOk = x.index[a]
+	var bIdx, bOk = x.index[b]
+	if !aOk || !bOk {
+		return nil
+	}
+	return x.nodes[x.lca(aIdx, bIdx)]
+}
+
+// Distance returns the number of edges on the path between the nodes.
+func (x *PartitionLCAIndex) Distance(a *PartitionNode, b *PartitionNode) int {
+	var aIdx, aOk = x.index[a]
+	var bIdx, bOk = x.index[b]
+	if !aOk || !bOk {
+		return -1
+	}
+	return x.distance(aIdx, bIdx)
+}
@@ -0,0 +1,136 @@
+package tree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Discrepancy is a node of a partition tree breaking an invariant.
+type Discrepancy struct {
+	Node    *PartitionNode
+	Problem string
+}
+
+// CheckReport lists discrepancies of a partition tree found by Check.
+type CheckReport struct {
+	Nodes         int
+	Discrepancies []Discrepancy
+}
+
+// return nil for a consistent tree
+func (r *CheckReport) Err() error {
+	if len(r.Discrepancies) == 0 {
+		return nil
+	}
+	return r
+}
+
+func (r *CheckReport) Error() string {
+	var lines = []string{"partition tree : tree is not consistent"}
+	for idx, d := range r.Discrepancies {
+		if idx == maxListed {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(r.Discrepancies)-maxListed))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %q: %s", d.Node.Name, d.Problem))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Check verifies the subtree of the node: every child has the node as its parent
+// and is reached once, and sizes of every subtree equal the node size plus sizes
+// of subtrees of its children. A drifted size is reported at the node where it
+// breaks the sum together with the size recomputed bottom-up from node sizes.
+func Check(pRoot *PartitionNode) *CheckReport {
+	var r = &CheckReport{}
+	var add = func(pNode *PartitionNode, format string, args ...interface{}) {
+		r.Discrepancies = append(r.Discrepancies, Discrepancy{Node: pNode, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	// pre-order walk which doesn't enter a node twice, so cycles of children end
+	var order []*PartitionNode
+	var seen = make(map[*PartitionNode]bool)
+	pRoot.PreOrder(func(pNode *PartitionNode, _ int) bool {
+		if seen[pNode] {
+			add(pNode, "reached twice, it is a child of several nodes or of its descendant")
+			return false
+		}
+		seen[pNode] = true
+		order = append(order, pNode)
+		for _, child := range pNode.Children {
+			if child == nil {
+				add(pNode, "has a nil child")
+			} else if child.Parent != pNode {
+				add(child, "is a child of %q but its parent is %s", pNode.Name, nameOf(child.Parent))
+			}
+		}
+		return true
+	})
+	r.Nodes = len(order)
+
+	// bottom-up: descendants follow their ancestors in pre-order
+	var recomputed = make(map[*PartitionNode]int64, len(order))
+	for idx := len(order) - 1; idx >= 0; idx-- {
+		var pNode = order[idx]
+		var sum, stored = pNode.NodeSize, pNode.NodeSize
+		var extraStored = append([]int64(nil), pNode.ExtraNodeSize...)
+		for _, child := range pNode.Children {
+			if child == nil || child.Parent != pNode {
+				continue
+			}
+			sum += recomputed[child]
+			stored += child.SubTreeSize
+			AddVector(&extraStored, child.ExtraSubTreeSize, 1)
+		}
+		recomputed[pNode] = sum
+
+		if pNode.SubTreeSize != stored {
+			add(pNode, "subtree size %d, node size plus subtrees of children %d, recomputed %d",
+				pNode.SubTreeSize, stored, sum)
+		}
+		if !equalVectors(pNode.ExtraSubTreeSize, extraStored) {
+			add(pNode, "extra subtree size %v, node size plus subtrees of children %v",
+				pNode.ExtraSubTreeSize, extraStored)
+		}
+	}
+
+	return r
+}
+
+// RecomputeSubTreeSizes sets sizes of every subtree to the sum of node sizes in it,
+// which repairs sizes reported by Check when parent pointers are right.
+func (pNode *PartitionNode) RecomputeSubTreeSizes() {
+	pNode.PostOrder(func(pNode *PartitionNode) {
+		pNode.SubTreeSize = pNode.NodeSize
+		pNode.ExtraSubTreeSize = append([]int64(nil), pNode.ExtraNodeSize...)
+		for _, child := range pNode.Children {
+			pNode.SubTreeSize += child.SubTreeSize
+			AddVector(&pNode.ExtraSubTreeSize, child.ExtraSubTreeSize, 1)
+		}
+	})
+}
+
+func nameOf(pNode *PartitionNode) string {
+	if pNode == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%q", pNode.Name)
+}
+
+// equalVectors compares vectors of resources, missing values are zero
+func equalVectors(a []int64, b []int64) bool {
+	for idx := 0; idx < len(a) || idx < len(b); idx++ {
+		var x, y int64
+		if idx < len(a) {
+			x = a[idx]
+		}
+		if idx < len(b) {
+			y = b[idx]
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
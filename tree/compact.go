@@ -0,0 +1,325 @@
+package tree
+
+import (
+	"errors"
+	"sort"
+)
+
+//---------------------------Compact Tree----------------------
+
+// NodeID is the index of a node in a CompactTree.
+type NodeID int32
+
+// NoNode is the parent of the root.
+const NoNode NodeID = -1
+
+// Names interns node names: every name is stored once and identified by its index.
+type Names struct {
+	names []string
+	ids   map[string]NodeID
+}
+
+func NewNames() *Names {
+	return &Names{ids: make(map[string]NodeID)}
+}
+
+// Intern returns the index of the name, a new name is added to the end.
+func (n *Names) Intern(name string) NodeID {
+	if id, ok := n.ids[name]; ok {
+		return id
+	}
+	return n.add(name)
+}
+
+// add appends the name even if it is already known, the first index is kept for lookups
+func (n *Names) add(name string) NodeID {
+	var id = NodeID(len(n.names))
+	n.names = append(n.names, name)
+	if _, ok := n.ids[name]; !ok {
+		n.ids[name] = id
+	}
+	return id
+}
+
+func (n *Names) Name(id NodeID) string {
+	return n.names[id]
+}
+
+func (n *Names) ID(name string) (NodeID, bool) {
+	var id, ok = n.ids[name]
+	return id, ok
+}
+
+func (n *Names) Len() int {
+	return len(n.names)
+}
+
+// CompactNode is a node of a CompactTree.
+type CompactNode struct {
+	Parent NodeID // NoNode for the root
+	End    NodeID // the subtree of node id holds nodes id..End-1
+
+	NodeSize    int64
+	SubTreeSize int64
+
+	Virtual bool // super-root of a forest, see Node.Virtual
+}
+
+// CompactTree is an array of nodes in pre-order: every subtree is the interval
+// of ids [id, End) of its Euler tour, children of a node follow it in order.
+// The root has id 0, names are interned with the same ids.
+// It holds a single resource and replaces pointers and string-keyed maps
+// of PartitionNode by int32 ids, so huge trees take less memory and GC time.
+type CompactTree struct {
+	Nodes []CompactNode
+	Names *Names
+}
+
+// NewCompactTree builds the tree of the child to parent map, children of every node
+// are sorted by name as by NewTree.
+func NewCompactTree(childToParent map[string]string) (*CompactTree, error) {
+	if err := ValidateMap(childToParent).Err(); err != nil {
+		return nil, err
+	}
+
+	return buildCompactTree(childToParent, ""), nil
+}
+
+// NewCompactForest builds all trees of the child to parent map under
+// the virtual super-root, see NewForest and NewSuperRoot.
+func NewCompactForest(childToParent map[string]string, superRoot string) (*CompactTree, error) {
+	var report = ValidateMap(childToParent)
+	report.Forest = true
+	if err := report.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := childToParent[superRoot]; ok {
+		return nil, errors.New("tree : super-root name '" + superRoot + "' is taken by a node")
+	}
+
+	return buildCompactTree(childToParent, superRoot), nil
+}
+
+// buildCompactTree builds a valid map, roots are joined under the super-root unless it is empty
+func buildCompactTree(childToParent map[string]string, superRoot string) *CompactTree {
+	// temporary ids are indexes of names sorted by name
+	var sorted = make([]string, 0, len(childToParent))
+	for child := range childToParent {
+		sorted = append(sorted, child)
+	}
+	sort.Strings(sorted)
+	var tmpIds = make(map[string]NodeID, len(sorted))
+	for idx, name := range sorted {
+		tmpIds[name] = NodeID(idx)
+	}
+
+	// children lists sorted by name in one array
+	var parentOf = make([]NodeID, len(sorted))
+	var offsets = make([]int32, len(sorted)+1)
+	var roots []NodeID
+	for idx, name := range sorted {
+		parentOf[idx] = tmpIds[childToParent[name]]
+		if parentOf[idx] == NodeID(idx) {
+			parentOf[idx] = NoNode
+			roots = append(roots, NodeID(idx))
+		} else {
+			offsets[parentOf[idx]+1]++
+		}
+	}
+	tmpIds = nil
+	for idx := 1; idx < len(offsets); idx++ {
+		offsets[idx] += offsets[idx-1]
+	}
+	var children = make([]NodeID, offsets[len(sorted)])
+	var filled = append([]int32(nil), offsets[:len(sorted)]...)
+	for idx, parent := range parentOf {
+		if parent != NoNode {
+			children[filled[parent]] = NodeID(idx)
+			filled[parent]++
+		}
+	}
+	filled = nil
+
+	var t = &CompactTree{Nodes: make([]CompactNode, 0, len(sorted)+1), Names: NewNames()}
+	var rootParent = NoNode
+	if superRoot != "" {
+		t.Nodes = append(t.Nodes, CompactNode{Parent: NoNode, Virtual: true})
+		t.Names.add(superRoot)
+		rootParent = 0
+	}
+
+	// pre-order walk with an explicit stack, parent is the new id of the parent
+	type item struct {
+		tmpId  NodeID
+		parent NodeID
+	}
+	var stack = make([]item, 0, len(roots))
+	for idx := len(roots) - 1; idx >= 0; idx-- {
+		stack = append(stack, item{roots[idx], rootParent})
+	}
+	for len(stack) > 0 {
+		var top = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		var id = NodeID(len(t.Nodes))
+		t.Nodes = append(t.Nodes, CompactNode{Parent: top.parent})
+		t.Names.add(sorted[top.tmpId])
+		for idx := offsets[top.tmpId+1] - 1; idx >= offsets[top.tmpId]; idx-- {
+			stack = append(stack, item{children[idx], id})
+		}
+	}
+	t.updateEnds()
+
+	return t
+}
+
+// NewCompactTreeOf copies a partition tree with its sizes and chunks.
+func NewCompactTreeOf(pRoot *PartitionNode) *CompactTree {
+	var t = &CompactTree{Names: NewNames()}
+	var ids = make(map[*PartitionNode]NodeID)
+	pRoot.PreOrder(func(pNode *PartitionNode, _ int) bool {
+		var parent = NoNode
+		if pNode != pRoot {
+			parent = ids[pNode.Parent]
+		}
+		ids[pNode] = NodeID(len(t.Nodes))
+		t.Nodes = append(t.Nodes, CompactNode{Parent: parent, NodeSize: pNode.NodeSize,
+			SubTreeSize: pNode.SubTreeSize, Virtual: pNode.Virtual})
+		t.Names.add(pNode.Name)
+		return true
+	})
+	t.updateEnds()
+
+	return t
+}
+
+// updateEnds sets intervals of subtrees: a node ends where its last descendant does
+func (t *CompactTree) updateEnds() {
+	for id := range t.Nodes {
+		t.Nodes[id].End = NodeID(id + 1)
+	}
+	for id := len(t.Nodes) - 1; id > 0; id-- {
+		var parent = t.Nodes[id].Parent
+		if parent != NoNode && t.Nodes[id].End > t.Nodes[parent].End {
+			t.Nodes[parent].End = t.Nodes[id].End
+		}
+	}
+}
+
+// updateSubTreeSizes sums node sizes of every subtree, descendants go after their ancestors
+func (t *CompactTree) updateSubTreeSizes() {
+	for id := range t.Nodes {
+		t.Nodes[id].SubTreeSize = t.Nodes[id].NodeSize
+	}
+	for id := len(t.Nodes) - 1; id > 0; id-- {
+		if parent := t.Nodes[id].Parent; parent != NoNode {
+			t.Nodes[parent].SubTreeSize += t.Nodes[id].SubTreeSize
+		}
+	}
+}
+
+func (t *CompactTree) Len() int {
+	return len(t.Nodes)
+}
+
+func (t *CompactTree) Name(id NodeID) string {
+	return t.Names.Name(id)
+}
+
+// ID returns the node of the name, the first one if a chunk repeats a name.
+func (t *CompactTree) ID(name string) (NodeID, bool) {
+	return t.Names.ID(name)
+}
+
+// IsInSubtree reports whether the node descends from the root of the subtree or is the root.
+func (t *CompactTree) IsInSubtree(id NodeID, root NodeID) bool {
+	return root <= id && id < t.Nodes[root].End
+}
+
+// FirstChild returns the first child of the node or NoNode for a leaf.
+func (t *CompactTree) FirstChild(id NodeID) NodeID {
+	if id+1 < t.Nodes[id].End {
+		return id + 1
+	}
+	return NoNode
+}
+
+// NextSibling returns the next child of the parent of the node or NoNode for the last one.
+func (t *CompactTree) NextSibling(id NodeID) NodeID {
+	var parent = t.Nodes[id].Parent
+	if parent != NoNode && t.Nodes[id].End < t.Nodes[parent].End {
+		return t.Nodes[id].End
+	}
+	return NoNode
+}
+
+// SetSizes sets node sizes to loads and updates subtree sizes. It returns sorted names
+// of loads which are not in the tree, their loads are skipped as are loads of virtual nodes.
+func (t *CompactTree) SetSizes(loads map[string]int64) []string {
+	var unknown []string
+	for id := range t.Nodes {
+		t.Nodes[id].NodeSize = 0
+	}
+	for name, load := range loads {
+		var id, ok = t.ID(name)
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if !t.Nodes[id].Virtual {
+			t.Nodes[id].NodeSize += load
+		}
+	}
+	t.updateSubTreeSizes()
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// SplitLarge returns the tree where nodes larger than the volume keep the volume
+// and the rest goes to chunk children named with '#', as PreprocessPartitionTree does.
+// Chunks of a node form a chain, children of the node move under the last chunk.
+func (t *CompactTree) SplitLarge(volume int64) *CompactTree {
+	var large bool
+	for id := range t.Nodes {
+		large = large || t.Nodes[id].NodeSize > volume
+	}
+	if !large {
+		return t
+	}
+
+	var split = &CompactTree{Nodes: make([]CompactNode, 0, len(t.Nodes)), Names: NewNames()}
+	var lastChunk = make([]NodeID, len(t.Nodes)) // new id of the node or its last chunk
+	for id, node := range t.Nodes {
+		var parent = NoNode
+		if node.Parent != NoNode {
+			parent = lastChunk[node.Parent]
+		}
+
+		var name, rest = t.Name(NodeID(id)), node.NodeSize
+		for {
+			var newId = NodeID(len(split.Nodes))
+			split.Nodes = append(split.Nodes, CompactNode{Parent: parent, NodeSize: min64(rest, volume),
+				Virtual: node.Virtual}) // virtual nodes have no size, so they are never split
+			split.Names.add(name)
+			lastChunk[id] = newId
+			if rest <= volume {
+				break
+			}
+			rest -= volume
+			parent = newId
+			name += "#"
+		}
+	}
+	split.updateEnds()
+	split.updateSubTreeSizes()
+
+	return split
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
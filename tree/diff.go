@@ -0,0 +1,182 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DiffNode is a node which is only in one of the trees.
+type DiffNode struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"` // empty for a root
+}
+
+// Move is a node which has different parents in the trees.
+type Move struct {
+	Name      string `json:"name"`
+	OldParent string `json:"old_parent"` // empty for a root
+	NewParent string `json:"new_parent"`
+}
+
+// SizeChange is a node of both partition trees whose size or size of subtree differs.
+type SizeChange struct {
+	Name           string `json:"name"`
+	OldNodeSize    int64  `json:"old_node_size"`
+	NewNodeSize    int64  `json:"new_node_size"`
+	OldSubTreeSize int64  `json:"old_subtree_size"`
+	NewSubTreeSize int64  `json:"new_subtree_size"`
+}
+
+// return change of the subtree size
+func (c SizeChange) Delta() int64 {
+	return c.NewSubTreeSize - c.OldSubTreeSize
+}
+
+// Diff lists changes from an old tree to a new one, nodes are matched by name.
+// Children of a moved node are not moved unless their parent changes too.
+type Diff struct {
+	Added   []DiffNode   `json:"added"`   // sorted by name
+	Removed []DiffNode   `json:"removed"` // sorted by name
+	Moved   []Move       `json:"moved"`   // sorted by name
+	Resized []SizeChange `json:"resized"` // largest changes of subtree size first, partition trees only
+}
+
+// DiffTrees compares structure of two trees.
+func DiffTrees(oldRoot *Node, newRoot *Node) *Diff {
+	return diffSnapshots(oldRoot.snapshot(), newRoot.snapshot(), false)
+}
+
+// DiffPartitionTrees compares structure and sizes of two partition trees.
+func DiffPartitionTrees(oldRoot *PartitionNode, newRoot *PartitionNode) *Diff {
+	return diffSnapshots(oldRoot.snapshot(), newRoot.snapshot(), true)
+}
+
+// return true if the trees are the same
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Moved) == 0 && len(d.Resized) == 0
+}
+
+// snapshotIndex maps names to nodes of a snapshot, the last node of a name wins
+func snapshotIndex(s *snapshot) map[string]int {
+	var index = make(map[string]int, len(s.Nodes))
+	for idx, node := range s.Nodes {
+		index[node.Name] = idx
+	}
+	return index
+}
+
+func (s *snapshot) parentName(idx int) string {
+	if s.Nodes[idx].Parent < 0 {
+		return ""
+	}
+	return s.Nodes[s.Nodes[idx].Parent].Name
+}
+
+func diffSnapshots(oldTree *snapshot, newTree *snapshot, sizes bool) *Diff {
+	var d = &Diff{Added: []DiffNode{}, Removed: []DiffNode{}, Moved: []Move{}, Resized: []SizeChange{}}
+	var oldIndex, newIndex = snapshotIndex(oldTree), snapshotIndex(newTree)
+
+	for name, oldIdx := range oldIndex {
+		var newIdx, ok = newIndex[name]
+		if !ok {
+			d.Removed = append(d.Removed, DiffNode{Name: name, Parent: oldTree.parentName(oldIdx)})
+			continue
+		}
+
+		var oldParent, newParent = oldTree.parentName(oldIdx), newTree.parentName(newIdx)
+		if oldParent != newParent {
+			d.Moved = append(d.Moved, Move{Name: name, OldParent: oldParent, NewParent: newParent})
+		}
+
+		var oldNode, newNode = oldTree.Nodes[oldIdx], newTree.Nodes[newIdx]
+		if sizes && (oldNode.NodeSize != newNode.NodeSize || oldNode.SubTreeSize != newNode.SubTreeSize) {
+			d.Resized = append(d.Resized, SizeChange{Name: name,
+				OldNodeSize: oldNode.NodeSize, NewNodeSize: newNode.NodeSize,
+				OldSubTreeSize: oldNode.SubTreeSize, NewSubTreeSize: newNode.SubTreeSize})
+		}
+	}
+	for name, newIdx := range newIndex {
+		if _, ok := oldIndex[name]; !ok {
+			d.Added = append(d.Added, DiffNode{Name: name, Parent: newTree.parentName(newIdx)})
+		}
+	}
+
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Name < d.Added[j].Name })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Name < d.Removed[j].Name })
+	sort.Slice(d.Moved, func(i, j int) bool { return d.Moved[i].Name < d.Moved[j].Name })
+	sort.Slice(d.Resized, func(i, j int) bool {
+		var a, b = abs64(d.Resized[i].Delta()), abs64(d.Resized[j].Delta())
+		if a != b {
+			return a > b
+		}
+		return d.Resized[i].Name < d.Resized[j].Name
+	})
+
+	return d
+}
+
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func (d *Diff) WriteJSON(w io.Writer) error {
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(d)
+}
+
+func (d *Diff) WriteText(w io.Writer) error {
+	var lines []string
+	var printf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	var parentOf = func(parent string) string {
+		if parent == "" {
+			return "as a root"
+		}
+		return "under " + parent
+	}
+
+	if d.Empty() {
+		printf("trees are the same")
+	}
+	if len(d.Added) > 0 {
+		printf("Added nodes: %d", len(d.Added))
+		for _, node := range d.Added {
+			printf("  %-20s %s", node.Name, parentOf(node.Parent))
+		}
+	}
+	if len(d.Removed) > 0 {
+		printf("Removed nodes: %d", len(d.Removed))
+		for _, node := range d.Removed {
+			printf("  %-20s %s", node.Name, parentOf(node.Parent))
+		}
+	}
+	if len(d.Moved) > 0 {
+		printf("Moved nodes: %d", len(d.Moved))
+		for _, move := range d.Moved {
+			printf("  %-20s %s -> %s", move.Name, parentOf(move.OldParent), parentOf(move.NewParent))
+		}
+	}
+	if len(d.Resized) > 0 {
+		printf("Size changes: %d", len(d.Resized))
+		for _, change := range d.Resized {
+			printf("  %-20s subtree %d -> %d (%+d), node %d -> %d", change.Name,
+				change.OldSubTreeSize, change.NewSubTreeSize, change.Delta(),
+				change.OldNodeSize, change.NewNodeSize)
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package tree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Trees are encoded as a flat list of nodes in pre-order, every node refers
+// to its parent by index. Unlike nested objects it encodes trees of any depth
+// and keeps the order of children. Chunk nodes of PreprocessPartitionTree
+// are ordinary nodes of the list.
+
+// snapshotNode is one encoded node, sizes are zero for a Node
+type snapshotNode struct {
+	Name             string  `json:"name"`
+	Parent           int     `json:"parent"` // index of the parent, -1 for the root
+	Virtual          bool    `json:"virtual,omitempty"`
+	NodeSize         int64   `json:"node_size,omitempty"`
+	SubTreeSize      int64   `json:"subtree_size,omitempty"`
+	ExtraNodeSize    []int64 `json:"extra_node_size,omitempty"`
+	ExtraSubTreeSize []int64 `json:"extra_subtree_size,omitempty"`
+}
+
+type snapshot struct {
+	Nodes []snapshotNode `json:"nodes"`
+}
+
+func (node *Node) snapshot() *snapshot {
+	var s = &snapshot{}
+	var index = make(map[*Node]int)
+	node.PreOrder(func(n *Node, _ int) bool {
+		var parent = -1
+		if n != node {
+			parent = index[n.Parent]
+		}
+		index[n] = len(s.Nodes)
+		s.Nodes = append(s.Nodes, snapshotNode{Name: n.Name, Parent: parent, Virtual: n.Virtual})
+		return true
+	})
+
+	return s
+}
+
+func (pNode *PartitionNode) snapshot() *snapshot {
+	var s = &snapshot{}
+	var index = make(map[*PartitionNode]int)
+	pNode.PreOrder(func(n *PartitionNode, _ int) bool {
+		var parent = -1
+		if n != pNode {
+			parent = index[n.Parent]
+		}
+		index[n] = len(s.Nodes)
+		s.Nodes = append(s.Nodes, snapshotNode{Name: n.Name, Parent: parent, Virtual: n.Virtual,
+			NodeSize: n.NodeSize, SubTreeSize: n.SubTreeSize,
+			ExtraNodeSize: n.ExtraNodeSize, ExtraSubTreeSize: n.ExtraSubTreeSize})
+		return true
+	})
+
+	return s
+}
+
+// validate checks that the first node is the only root and parents precede their children
+func (s *snapshot) validate() error {
+	if len(s.Nodes) == 0 {
+		return errors.New("tree : snapshot has no nodes")
+	}
+	for idx, node := range s.Nodes {
+		if idx == 0 && node.Parent != -1 {
+			return errors.New("tree : the first node of snapshot is not a root")
+		}
+		if idx > 0 && (node.Parent < 0 || node.Parent >= idx) {
+			return fmt.Errorf("tree : node %d %q of snapshot has parent %d which doesn't precede it",
+				idx, node.Name, node.Parent)
+		}
+	}
+
+	return nil
+}
+
+// restoreNode builds the tree into root
+func (s *snapshot) restoreNode(root *Node) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	var nodes = make([]*Node, len(s.Nodes))
+	for idx, sNode := range s.Nodes {
+		if idx == 0 {
+			*root = Node{}
+			nodes[0] = root
+		} else {
+			nodes[idx] = &Node{Parent: nodes[sNode.Parent]}
+			nodes[sNode.Parent].Children = append(nodes[sNode.Parent].Children, nodes[idx])
+		}
+		nodes[idx].Name = sNode.Name
+		nodes[idx].Virtual = sNode.Virtual
+	}
+
+	return nil
+}
+
+func (s *snapshot) restorePartitionNode(root *PartitionNode) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	var nodes = make([]*PartitionNode, len(s.Nodes))
+	for idx, sNode := range s.Nodes {
+		if idx == 0 {
+			*root = PartitionNode{}
+			nodes[0] = root
+		} else {
+			nodes[idx] = &PartitionNode{Parent: nodes[sNode.Parent]}
+			nodes[sNode.Parent].Children = append(nodes[sNode.Parent].Children, nodes[idx])
+		}
+		var pNode = nodes[idx]
+		pNode.Name = sNode.Name
+		pNode.Virtual = sNode.Virtual
+		pNode.Children = make([]*PartitionNode, 0) // as copyTree makes them
+		pNode.NodeSize, pNode.SubTreeSize = sNode.NodeSize, sNode.SubTreeSize
+		pNode.ExtraNodeSize, pNode.ExtraSubTreeSize = sNode.ExtraNodeSize, sNode.ExtraSubTreeSize
+	}
+
+	return nil
+}
+
+//---------------------------JSON encoding----------------------
+
+func (node *Node) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(node.snapshot())
+}
+
+// ReadJSONTree reads a tree written by Node.WriteJSON.
+func ReadJSONTree(r io.Reader) (*Node, error) {
+	var s snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	var root = &Node{}
+	if err := s.restoreNode(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (pNode *PartitionNode) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(pNode.snapshot())
+}
+
+// ReadJSONPartitionTree reads a tree written by PartitionNode.WriteJSON
+// with sizes of all nodes.
+func ReadJSONPartitionTree(r io.Reader) (*PartitionNode, error) {
+	var s snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	var root = &PartitionNode{}
+	if err := s.restorePartitionNode(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+//---------------------------Binary encoding----------------------
+
+// The binary encoding starts with binaryMagic, the version, whether nodes have sizes
+// and the number of nodes. Every node is the parent index + 1, the name, flags
+// and, for partition trees, the sizes. Integers are varints as by encoding/binary.
+// Either tree can be read by both readers: sizes are dropped or left zero.
+var binaryMagic = []byte("DHTR")
+
+const binaryVersion = 1
+
+const virtualFlag = 1
+
+func (node *Node) WriteBinary(w io.Writer) error {
+	return node.snapshot().write(w, false)
+}
+
+// ReadBinaryTree reads a tree written by Node.WriteBinary.
+func ReadBinaryTree(r io.Reader) (*Node, error) {
+	var s, err = readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root = &Node{}
+	if err = s.restoreNode(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (pNode *PartitionNode) WriteBinary(w io.Writer) error {
+	return pNode.snapshot().write(w, true)
+}
+
+// ReadBinaryPartitionTree reads a tree written by PartitionNode.WriteBinary.
+func ReadBinaryPartitionTree(r io.Reader) (*PartitionNode, error) {
+	var s, err = readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root = &PartitionNode{}
+	if err = s.restorePartitionNode(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (s *snapshot) write(w io.Writer, sizes bool) error {
+	var bw = bufio.NewWriter(w)
+	var buf [binary.MaxVarintLen64]byte
+	var putUvarint = func(value uint64) {
+		_, _ = bw.Write(buf[:binary.PutUvarint(buf[:], value)])
+	}
+	var putVarints = func(values []int64) {
+		putUvarint(uint64(len(values)))
+		for _, value := range values {
+			_, _ = bw.Write(buf[:binary.PutVarint(buf[:], value)])
+		}
+	}
+
+	_, _ = bw.Write(binaryMagic)
+	putUvarint(binaryVersion)
+	if sizes {
+		putUvarint(1)
+	} else {
+		putUvarint(0)
+	}
+	putUvarint(uint64(len(s.Nodes)))
+	for _, node := range s.Nodes {
+		putUvarint(uint64(node.Parent + 1))
+		putUvarint(uint64(len(node.Name)))
+		_, _ = bw.WriteString(node.Name)
+
+		var flags uint64
+		if node.Virtual {
+			flags |= virtualFlag
+		}
+		putUvarint(flags)
+
+		if sizes {
+			putVarints([]int64{node.NodeSize, node.SubTreeSize})
+			putVarints(node.ExtraNodeSize)
+			putVarints(node.ExtraSubTreeSize)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// maxBinaryLength limits lengths of names and size vectors read from a binary tree,
+// so a broken file can't make the reader allocate huge slices
+const maxBinaryLength = 1 << 20
+
+var errBadBinary = errors.New("tree : not a binary tree or it is broken")
+
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	var br = bufio.NewReader(r)
+	var err error
+	var getUvarint = func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var value uint64
+		value, err = binary.ReadUvarint(br)
+		return value
+	}
+	var getLength = func(limit uint64) int {
+		var length = getUvarint()
+		if err == nil && length > limit {
+			err = errBadBinary
+		}
+		if err != nil {
+			return 0
+		}
+		return int(length)
+	}
+	var getVarints = func() []int64 {
+		var length = getLength(maxBinaryLength)
+		if err != nil || length == 0 {
+			return nil
+		}
+		var values = make([]int64, length)
+		for idx := range values {
+			if values[idx], err = binary.ReadVarint(br); err != nil {
+				return nil
+			}
+		}
+		return values
+	}
+
+	var magic = make([]byte, len(binaryMagic))
+	if _, err = io.ReadFull(br, magic); err != nil || string(magic) != string(binaryMagic) {
+		return nil, errBadBinary
+	}
+	if version := getUvarint(); err == nil && version != binaryVersion {
+		return nil, fmt.Errorf("tree : unsupported version %d of binary tree", version)
+	}
+	var sizes = getUvarint() == 1
+
+	var count = getLength(math.MaxInt32) // nodes are appended one by one
+	var s = &snapshot{}
+	for idx := 0; idx < count && err == nil; idx++ {
+		var node = snapshotNode{Parent: int(getUvarint()) - 1}
+		var name = make([]byte, getLength(maxBinaryLength))
+		if err == nil {
+			_, err = io.ReadFull(br, name)
+		}
+		node.Name = string(name)
+		node.Virtual = getUvarint()&virtualFlag != 0
+
+		if sizes {
+			var values = getVarints()
+			if err == nil && len(values) != 2 {
+				err = errBadBinary
+			}
+			if err == nil {
+				node.NodeSize, node.SubTreeSize = values[0], values[1]
+			}
+			node.ExtraNodeSize = getVarints()
+			node.ExtraSubTreeSize = getVarints()
+		}
+		s.Nodes = append(s.Nodes, node)
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errBadBinary
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
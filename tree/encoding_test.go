@@ -0,0 +1,61 @@
+package tree
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	var pRoot = randomPartitionTree(rand.New(rand.NewSource(1)), 200)[0]
+	pRoot.Children[0].Virtual = true
+
+	for _, format := range []struct {
+		name  string
+		write func(pNode *PartitionNode, buf *bytes.Buffer) error
+		read  func(buf *bytes.Buffer) (*PartitionNode, error)
+	}{
+		{"json",
+			func(pNode *PartitionNode, buf *bytes.Buffer) error { return pNode.WriteJSON(buf) },
+			func(buf *bytes.Buffer) (*PartitionNode, error) { return ReadJSONPartitionTree(buf) }},
+		{"binary",
+			func(pNode *PartitionNode, buf *bytes.Buffer) error { return pNode.WriteBinary(buf) },
+			func(buf *bytes.Buffer) (*PartitionNode, error) { return ReadBinaryPartitionTree(buf) }},
+	} {
+		var buf bytes.Buffer
+		if err := format.write(pRoot, &buf); err != nil {
+			t.Fatal(err)
+		}
+		var decoded, err = format.read(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", format.name, err)
+		}
+		if !reflect.DeepEqual(decoded.snapshot(), pRoot.snapshot()) {
+			t.Errorf("%s: decoded tree differs", format.name)
+		}
+	}
+}
+
+func TestJSONTreeRoundTrip(t *testing.T) {
+	var root, err = NewTree(map[string]string{"r": "r", "a": "r", "b": "r", "c": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err = root.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ReadJSONTree(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.snapshot(), root.snapshot()) {
+		t.Errorf("decoded tree %+v, expected %+v", decoded.snapshot(), root.snapshot())
+	}
+
+	if _, err = ReadJSONTree(bytes.NewBufferString(`{"nodes": [{"name": "a", "parent": 0}]}`)); err == nil {
+		t.Errorf("a root referring to itself is decoded")
+	}
+}
@@ -0,0 +1,74 @@
+package tree
+
+import (
+	"errors"
+	"sort"
+)
+
+// AddVector adds sign × delta to *dst element-wise, *dst is extended when it is shorter.
+func AddVector(dst *[]int64, delta []int64, sign int64) {
+	for len(*dst) < len(delta) {
+		*dst = append(*dst, 0)
+	}
+
+	for idx, value := range delta {
+		(*dst)[idx] += sign * value
+	}
+}
+
+// AddToExtraNodeSize is AddToNodeSize for additional resources.
+func (pNode *PartitionNode) AddToExtraNodeSize(extra []int64) { // extra < 0 allowed
+	pNode.addToExtraNodeSize(extra, 1)
+}
+
+func (pNode *PartitionNode) addToExtraNodeSize(extra []int64, sign int64) {
+	if len(extra) == 0 {
+		return
+	}
+	var delta = append([]int64(nil), extra...) // extra may be the node's own slice
+
+	AddVector(&pNode.ExtraNodeSize, delta, sign)
+	for ptr := pNode; ptr != nil; ptr = ptr.Parent {
+		AddVector(&ptr.ExtraSubTreeSize, delta, sign)
+	}
+}
+
+// SetInitialExtraSize is SetInitialSize for additional resources.
+func (pNode *PartitionNode) SetInitialExtraSize(extraPerEpoch []map[string][]int64, initEpochs int,
+	unknown *UnknownNodes) error {
+	if !pNode.isRoot() {
+		return errors.New("partition tree : need partition root")
+	}
+	if pNode.ExtraSubTreeSize != nil {
+		return errors.New("partition tree : tree has already initial size of additional resources")
+	}
+	var nameToPartNode, err = pNode.MapNameToPartitionNode()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < initEpochs && i < len(extraPerEpoch); i++ {
+		if err = unknown.CheckExtra(extraPerEpoch[i], nameToPartNode); err != nil {
+			return err
+		}
+
+		var names = make([]string, 0, len(extraPerEpoch[i]))
+		for name := range extraPerEpoch[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var pNode, _, err = unknown.ResolveExtra(name, nameToPartNode)
+			if err != nil {
+				return err
+			}
+			if pNode != nil && !pNode.Virtual {
+				AddVector(&pNode.ExtraNodeSize, extraPerEpoch[i][name], 1)
+			}
+		}
+	}
+	pNode.RecomputeSubTreeSizes()
+
+	return nil
+}
@@ -2,28 +2,69 @@ package tree
 
 import (
 	"errors"
-	"fmt"
+	"math/rand"
+	"sort"
 )
 
 type Node struct {
 	Name     string // name string
 	Parent   *Node
 	Children []*Node
+
+	Virtual bool // super-root of a forest, it has no weight of its own
 }
 
+// NewTree builds the tree of the child to parent map, children of every node are sorted by name.
 func NewTree(childToParent map[string]string) (*Node, error) {
-	var root = buildTree(childToParent)
-	var ok = ValidateTree(root)
-	if !ok {
-		return nil, errors.New("tree : tree is not valid")
+	if err := ValidateMap(childToParent).Err(); err != nil {
+		return nil, err
+	}
+
+	return buildTree(childToParent)[0], nil
+}
+
+// DefaultSuperRoot is the name of the virtual root which joins the trees of a forest.
+const DefaultSuperRoot = "#forest"
+
+// NewForest builds every tree of the child to parent map, roots are sorted by name as children are.
+// Rows must form one or more trees, see ValidationReport.Forest and Err.
+func NewForest(childToParent map[string]string) ([]*Node, error) {
+	var report = ValidateMap(childToParent)
+	report.Forest = true
+	if err := report.Err(); err != nil {
+		return nil, err
 	}
 
-	return root, nil
+	return buildTree(childToParent), nil
 }
 
-func buildTree(tenantsToParent map[string]string) *Node {
+// NewSuperRoot makes a virtual root with the roots as its children.
+// The virtual root carries no weight and packing never places it into a bin.
+func NewSuperRoot(name string, roots []*Node) (*Node, error) {
+	var superRoot = &Node{Name: name, Virtual: true}
+	for _, root := range roots {
+		if !root.isRoot() {
+			return nil, errors.New("tree : need root, node '" + root.Name + "' has a parent")
+		}
+		var taken bool
+		root.PreOrder(func(node *Node, _ int) bool {
+			taken = taken || node.Name == name
+			return !taken
+		})
+		if taken {
+			return nil, errors.New("tree : super-root name '" + name + "' is taken by a node")
+		}
+		root.Parent = superRoot
+		superRoot.Children = append(superRoot.Children, root)
+	}
+
+	return superRoot, nil
+}
+
+// return roots of all trees sorted by name
+func buildTree(tenantsToParent map[string]string) []*Node {
 	var nameToNode = make(map[string]*Node)
-	var root *Node
+	var roots []*Node
 	for childName, parentName := range tenantsToParent {
 
 		_, ok := nameToNode[parentName]
@@ -36,7 +77,7 @@ func buildTree(tenantsToParent map[string]string) *Node {
 		}
 
 		if childName == parentName { // root case
-			root = nameToNode[childName]
+			roots = append(roots, nameToNode[childName])
 		} else {
 			nameToNode[childName].Parent = nameToNode[parentName]
 
@@ -45,47 +86,42 @@ func buildTree(tenantsToParent map[string]string) *Node {
 		}
 	}
 
-	return root
-}
-
-func ValidateTree(root *Node) bool {
-	var visited = make(map[*Node]bool)
-	var allNodes, err = root.AllNodes()
-	if err != nil {
-		fmt.Println(err)
-		return false
+	// children are sorted by name, so the tree doesn't depend on the map order
+	for _, node := range nameToNode {
+		sortByName(node.Children)
 	}
+	sortByName(roots)
 
-	for _, node := range allNodes {
-		visited[node] = false
-	}
-
-	acyclic := isAcyclicDFS(root, visited)
-
-	allVisited := true
-	for _, v := range visited {
-		if v == false {
-			allVisited = false
-		}
-	}
+	return roots
+}
 
-	return acyclic && allVisited
+func sortByName(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
 }
 
-func isAcyclicDFS(node *Node, visited map[*Node]bool) bool {
-	if visited[node] == true {
-		return false
+// ValidateTree checks a built tree: every node must be reached from the root once.
+func ValidateTree(root *Node) *ValidationReport {
+	if root == nil {
+		return &ValidationReport{}
 	}
 
-	visited[node] = true
-
-	for _, child := range node.Children {
-		if isAcyclicDFS(child, visited) == false {
-			return false
+	var edges = []Edge{{Child: root.Name, Parent: root.Name}}
+	var visited = map[*Node]bool{root: true}
+	var stack = []*Node{root}
+	for len(stack) > 0 {
+		var node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, child := range node.Children {
+			edges = append(edges, Edge{Child: child.Name, Parent: node.Name})
+			if !visited[child] {
+				visited[child] = true
+				stack = append(stack, child)
+			}
 		}
 	}
 
-	return true
+	return Validate(edges)
 }
 
 // return slice of all nodes
@@ -95,21 +131,14 @@ func (node *Node) AllNodes() ([]*Node, error) { // []Node or []*Node?
 	}
 
 	var allNodes []*Node
-	allNodes = node.allNodesFunc(allNodes)
+	node.PreOrder(func(node *Node, _ int) bool {
+		allNodes = append(allNodes, node)
+		return true
+	})
 
 	return allNodes, nil
 }
 
-func (node *Node) allNodesFunc(allNodes []*Node) []*Node {
-	allNodes = append(allNodes, node)
-
-	for _, child := range node.Children {
-		allNodes = child.allNodesFunc(allNodes)
-	}
-
-	return allNodes
-}
-
 func (node *Node) isRoot() bool {
 	return node.Parent == nil
 }
@@ -123,25 +152,35 @@ type PartitionNode struct {
 
 	NodeSize    int64
 	SubTreeSize int64
+
+	ExtraNodeSize    []int64 // sizes of additional resources, nil for a single resource
+	ExtraSubTreeSize []int64
+
+	Virtual bool // super-root of a forest, see Node.Virtual
 }
 
 func NewPartitionTree(root *Node) *PartitionNode {
-	var pNode = copyTree(root, nil)
+	var pNode = copyTree(root)
 
 	return pNode
 }
 
-func copyTree(root *Node, parent *PartitionNode) *PartitionNode {
-	var pNode = &PartitionNode{}
-	pNode.Name = root.Name
-	pNode.Parent = parent
-
-	pNode.Children = make([]*PartitionNode, 0)
-	for _, child := range root.Children {
-		pNode.Children = append(pNode.Children, copyTree(child, pNode))
-	}
+func copyTree(root *Node) *PartitionNode {
+	var copies = make(map[*Node]*PartitionNode)
+	root.PreOrder(func(node *Node, _ int) bool {
+		var pNode = &PartitionNode{}
+		pNode.Name = node.Name
+		pNode.Virtual = node.Virtual
+		pNode.Children = make([]*PartitionNode, 0, len(node.Children))
+		if node != root {
+			pNode.Parent = copies[node.Parent]
+			pNode.Parent.Children = append(pNode.Parent.Children, pNode)
+		}
+		copies[node] = pNode
+		return true
+	})
 
-	return pNode
+	return copies[root]
 }
 
 func (pNode *PartitionNode) isRoot() bool {
@@ -155,19 +194,14 @@ func (pNode *PartitionNode) MapNameToPartitionNode() (map[string]*PartitionNode,
 	}
 
 	var nameToPartNode = make(map[string]*PartitionNode)
-	pNode.mapNameToPartitionNodeFunc(nameToPartNode)
+	pNode.PreOrder(func(pNode *PartitionNode, _ int) bool {
+		nameToPartNode[pNode.Name] = pNode
+		return true
+	})
 
 	return nameToPartNode, nil
 }
 
-func (pNode *PartitionNode) mapNameToPartitionNodeFunc(nameToPartNode map[string]*PartitionNode) {
-	nameToPartNode[pNode.Name] = pNode
-
-	for _, child := range pNode.Children {
-		child.mapNameToPartitionNodeFunc(nameToPartNode)
-	}
-}
-
 func (pNode *PartitionNode) AddToNodeSize(tasks int64) { // tasks < 0 allowed
 	pNode.NodeSize += tasks
 
@@ -176,28 +210,116 @@ func (pNode *PartitionNode) AddToNodeSize(tasks int64) { // tasks < 0 allowed
 	}
 }
 
-func (pNode *PartitionNode) RemoveChild(child *PartitionNode) {
-	for ptr := pNode; ptr != nil; ptr = ptr.Parent {
-		ptr.SubTreeSize -= child.SubTreeSize
+// Detach removes the node with its subtree from its parent and returns the parent,
+// nil for a root. The node becomes a root: its Parent is nil and the sizes
+// of the subtree are subtracted from all former ancestors.
+func (pNode *PartitionNode) Detach() *PartitionNode {
+	var parent = pNode.Parent
+	if parent == nil {
+		return nil
 	}
 
-	for idx := range pNode.Children {
-		if pNode.Children[idx] == child {
-			pNode.Children = append(pNode.Children[:idx], pNode.Children[idx+1:]...)
+	for ptr := parent; ptr != nil; ptr = ptr.Parent {
+		ptr.SubTreeSize -= pNode.SubTreeSize
+		AddVector(&ptr.ExtraSubTreeSize, pNode.ExtraSubTreeSize, -1)
+	}
+	for idx := range parent.Children {
+		if parent.Children[idx] == pNode {
+			parent.Children = append(parent.Children[:idx], parent.Children[idx+1:]...)
 			break
 		}
 	}
+	pNode.Parent = nil
+
+	return parent
 }
 
-func (pNode *PartitionNode) AppendChild(child *PartitionNode) {
-	for ptr := pNode; ptr != nil; ptr = ptr.Parent {
-		ptr.SubTreeSize += child.SubTreeSize
+// Reattach appends the detached node with its subtree to the children of the parent
+// and adds the sizes of the subtree to the parent and all its ancestors.
+func (pNode *PartitionNode) Reattach(parent *PartitionNode) error {
+	if pNode.Parent != nil {
+		return errors.New("partition tree : node '" + pNode.Name + "' is not detached")
+	}
+	if parent == nil {
+		return errors.New("partition tree : node can't be attached to nil parent")
+	}
+	for ptr := parent; ptr != nil; ptr = ptr.Parent {
+		if ptr == pNode {
+			return errors.New("partition tree : node can't be attached into its own subtree")
+		}
+	}
+
+	for ptr := parent; ptr != nil; ptr = ptr.Parent {
+		ptr.SubTreeSize += pNode.SubTreeSize
+		AddVector(&ptr.ExtraSubTreeSize, pNode.ExtraSubTreeSize, 1)
+	}
+	parent.Children = append(parent.Children, pNode)
+	pNode.Parent = parent
+
+	return nil
+}
+
+// MoveSubtree moves the child with its subtree to the end of the children of the new parent.
+func MoveSubtree(child *PartitionNode, newParent *PartitionNode) error {
+	if child.isRoot() {
+		return errors.New("partition tree : root can't be moved")
+	}
+	if newParent == nil {
+		return errors.New("partition tree : node can't be moved to nil parent")
+	}
+	for ptr := newParent; ptr != nil; ptr = ptr.Parent {
+		if ptr == child {
+			return errors.New("partition tree : node can't be moved into its own subtree")
+		}
 	}
 
+	child.Detach()
+	return child.Reattach(newParent)
+}
+
+// ShuffleChildren shuffles children of every node of the subtree.
+func (pNode *PartitionNode) ShuffleChildren(r *rand.Rand) {
+	pNode.PreOrder(func(pNode *PartitionNode, _ int) bool {
+		r.Shuffle(len(pNode.Children), func(i, j int) {
+			pNode.Children[i], pNode.Children[j] = pNode.Children[j], pNode.Children[i]
+		})
+		return true
+	})
+}
+
+// AddNode creates an empty child of the node.
+func (pNode *PartitionNode) AddNode(name string) *PartitionNode {
+	var child = &PartitionNode{Name: name, Parent: pNode, Children: make([]*PartitionNode, 0)}
 	pNode.Children = append(pNode.Children, child)
+
+	return child
+}
+
+// Delete removes the node from the tree, children of the node are moved to its parent.
+func (pNode *PartitionNode) Delete() error {
+	if pNode.isRoot() {
+		return errors.New("partition tree : root can't be deleted")
+	}
+
+	pNode.AddToNodeSize(-pNode.NodeSize)
+	pNode.addToExtraNodeSize(pNode.ExtraNodeSize, -1)
+	var parent = pNode.Detach()
+	for _, child := range append([]*PartitionNode(nil), pNode.Children...) {
+		child.Detach()
+		if err := child.Reattach(parent); err != nil {
+			return err
+		}
+	}
+
+	pNode.Children = nil
+
+	return nil
 }
 
-func (pNode *PartitionNode) SetInitialSize(tasksPerEpoch []map[string]int64, initEpochs int) error {
+// SetInitialSize sums weights of the first initEpochs epochs,
+// weights of nodes missing in the tree are handled by unknown.
+func (pNode *PartitionNode) SetInitialSize(tasksPerEpoch []map[string]int64, initEpochs int,
+	unknown *UnknownNodes) error {
 	if !pNode.isRoot() {
 		return errors.New("partition tree : need partition root")
 	}
@@ -210,10 +332,28 @@ func (pNode *PartitionNode) SetInitialSize(tasksPerEpoch []map[string]int64, ini
 	}
 
 	for i := 0; i < initEpochs && i < len(tasksPerEpoch); i++ {
-		for name, tasks := range tasksPerEpoch[i] {
-			nameToPartNode[name].AddToNodeSize(tasks)
+		if err = unknown.Check(tasksPerEpoch[i], nameToPartNode); err != nil {
+			return err
+		}
+
+		var names = make([]string, 0, len(tasksPerEpoch[i]))
+		for name := range tasksPerEpoch[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names) // unknown nodes are attached in the same order every run
+
+		for _, name := range names {
+			var tasks = tasksPerEpoch[i][name]
+			var pNode, _, err = unknown.Resolve(name, tasks, nameToPartNode)
+			if err != nil {
+				return err
+			}
+			if pNode != nil && !pNode.Virtual { // the super-root carries no weight
+				pNode.NodeSize += tasks
+			}
 		}
 	}
+	pNode.RecomputeSubTreeSizes() // one pass instead of walking to the root for every weight
 
 	return nil
 }
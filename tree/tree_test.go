@@ -0,0 +1,112 @@
+package tree
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// randomPartitionTree returns nodes of a random tree with random sizes, the root is the first one
+func randomPartitionTree(r *rand.Rand, count int) []*PartitionNode {
+	var childToParent = map[string]string{"0": "0"}
+	var weights = make(map[string]int64)
+	for idx := 1; idx < count; idx++ {
+		childToParent[strconv.Itoa(idx)] = strconv.Itoa(r.Intn(idx))
+	}
+	for name := range childToParent {
+		weights[name] = r.Int63n(100)
+	}
+	var root, _ = NewTree(childToParent)
+	var pRoot = NewPartitionTree(root)
+	_ = pRoot.SetInitialSize([]map[string]int64{weights}, 1, nil)
+
+	var nodes []*PartitionNode
+	pRoot.PreOrder(func(pNode *PartitionNode, _ int) bool {
+		pNode.ExtraNodeSize = []int64{pNode.NodeSize * 2}
+		nodes = append(nodes, pNode)
+		return true
+	})
+	pRoot.RecomputeSubTreeSizes()
+
+	return nodes
+}
+
+// return true if the node is in the subtree of the root
+func inSubtree(pNode *PartitionNode, root *PartitionNode) bool {
+	for ; pNode != nil; pNode = pNode.Parent {
+		if pNode == root {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRandomMoves(t *testing.T) {
+	var r = rand.New(rand.NewSource(1))
+	var nodes = randomPartitionTree(r, 200)
+	var total = nodes[0].SubTreeSize
+
+	var detached []*PartitionNode // roots of detached subtrees
+	for step := 0; step < 5000; step++ {
+		var pNode = nodes[r.Intn(len(nodes))]
+		var target = nodes[r.Intn(len(nodes))]
+		var op string
+		var err error
+		var wantErr bool
+		switch {
+		case len(detached) > 0 && r.Intn(3) == 0:
+			op = "Reattach"
+			var idx = r.Intn(len(detached))
+			pNode = detached[idx]
+			wantErr = inSubtree(target, pNode)
+			err = pNode.Reattach(target)
+			if err == nil {
+				detached = append(detached[:idx], detached[idx+1:]...)
+			}
+		case r.Intn(4) == 0:
+			op = "Detach"
+			if pNode.Detach() != nil {
+				detached = append(detached, pNode)
+			}
+		default:
+			op = "MoveSubtree"
+			wantErr = pNode.isRoot() || inSubtree(target, pNode)
+			err = MoveSubtree(pNode, target)
+		}
+		if (err != nil) != wantErr {
+			t.Fatalf("step %d: %s %q to %q: error %v", step, op, pNode.Name, target.Name, err)
+		}
+
+		var sum int64
+		for _, root := range append([]*PartitionNode{nodes[0]}, detached...) {
+			if err := Check(root).Err(); err != nil {
+				t.Fatalf("step %d: %s %q to %q: %v", step, op, pNode.Name, target.Name, err)
+			}
+			sum += root.SubTreeSize
+		}
+		if sum != total {
+			t.Fatalf("step %d: %s %q: total size %d, want %d", step, op, pNode.Name, sum, total)
+		}
+	}
+}
+
+func TestMoveToNilParent(t *testing.T) {
+	var nodes = randomPartitionTree(rand.New(rand.NewSource(1)), 20)
+	var pNode = nodes[len(nodes)-1]
+	var parent = pNode.Parent
+
+	if err := MoveSubtree(pNode, nil); err == nil {
+		t.Fatal("moved to nil parent")
+	}
+	if pNode.Parent != parent {
+		t.Errorf("node is detached by a rejected move")
+	}
+	if err := Check(nodes[0]).Err(); err != nil {
+		t.Error(err)
+	}
+
+	pNode.Detach()
+	if err := pNode.Reattach(nil); err == nil {
+		t.Error("reattached to nil parent")
+	}
+}
@@ -0,0 +1,183 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type UnknownNodePolicy int
+
+const (
+	FailOnUnknown UnknownNodePolicy = iota // stop with the list of unknown nodes
+	DropUnknown                            // ignore weights of unknown nodes and count them
+	AttachUnknown                          // create unknown nodes under UnknownNodes.Parent
+)
+
+// name of the default parent of attached nodes, it is created as an ordinary node under the root
+const UnknownParentName = "#unknown"
+
+// UnknownNodes handles weights of nodes which are missing in the tree
+// and remembers what was affected. A nil *UnknownNodes drops such weights silently.
+type UnknownNodes struct {
+	Policy UnknownNodePolicy
+	Parent string // parent of attached nodes, UnknownParentName if empty
+
+	DroppedRows   int
+	DroppedWeight int64
+	DroppedNodes  map[string]bool
+	AttachedNodes []string
+}
+
+type UnknownNodesError struct {
+	Names []string
+}
+
+func (e *UnknownNodesError) Error() string {
+	const maxNames = 10
+	var names = e.Names
+	if len(names) > maxNames {
+		names = names[:maxNames]
+	}
+
+	var msg = fmt.Sprintf("partition tree : weights of %d unknown nodes: %s", len(e.Names), strings.Join(names, ", "))
+	if len(e.Names) > maxNames {
+		msg += ", ..."
+	}
+	return msg
+}
+
+// Check returns UnknownNodesError listing all unknown nodes of the epoch if the policy is FailOnUnknown.
+func (u *UnknownNodes) Check(weights map[string]int64, nameToPartNode map[string]*PartitionNode) error {
+	if u == nil || u.Policy != FailOnUnknown {
+		return nil
+	}
+
+	var names = make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	return checkNames(names, nameToPartNode)
+}
+
+// CheckExtra is Check for weights of additional resources.
+func (u *UnknownNodes) CheckExtra(extra map[string][]int64, nameToPartNode map[string]*PartitionNode) error {
+	if u == nil || u.Policy != FailOnUnknown {
+		return nil
+	}
+
+	var names = make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	return checkNames(names, nameToPartNode)
+}
+
+func checkNames(names []string, nameToPartNode map[string]*PartitionNode) error {
+	var unknown []string
+	for _, name := range names {
+		if _, ok := nameToPartNode[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	return &UnknownNodesError{Names: unknown}
+}
+
+// Resolve returns the node to which the weight of name is added, nil if the weight is dropped.
+// Nodes created by AttachUnknown are added to nameToPartNode and returned
+// parents first, so that callers can place them into bins.
+func (u *UnknownNodes) Resolve(name string, weight int64,
+	nameToPartNode map[string]*PartitionNode) (*PartitionNode, []*PartitionNode, error) {
+	return u.resolve(name, weight, true, nameToPartNode)
+}
+
+// ResolveExtra is Resolve for weights of additional resources. They come in the rows
+// of the weights given to Resolve, so dropped rows and weights are not counted again.
+func (u *UnknownNodes) ResolveExtra(name string,
+	nameToPartNode map[string]*PartitionNode) (*PartitionNode, []*PartitionNode, error) {
+	return u.resolve(name, 0, false, nameToPartNode)
+}
+
+func (u *UnknownNodes) resolve(name string, weight int64, countDropped bool,
+	nameToPartNode map[string]*PartitionNode) (*PartitionNode, []*PartitionNode, error) {
+
+	if pNode, ok := nameToPartNode[name]; ok {
+		return pNode, nil, nil
+	}
+	if u == nil {
+		return nil, nil, nil
+	}
+
+	switch u.Policy {
+	case FailOnUnknown:
+		return nil, nil, &UnknownNodesError{Names: []string{name}}
+
+	case DropUnknown:
+		if u.DroppedNodes == nil {
+			u.DroppedNodes = make(map[string]bool)
+		}
+		u.DroppedNodes[name] = true
+		if countDropped {
+			u.DroppedRows++
+			u.DroppedWeight += weight
+		}
+		return nil, nil, nil
+	}
+
+	var created []*PartitionNode
+	var parent, ok = nameToPartNode[u.Parent]
+	if u.Parent != "" && !ok {
+		return nil, nil, fmt.Errorf("partition tree : unknown parent %q for unknown nodes", u.Parent)
+	}
+	if u.Parent == "" {
+		if parent, ok = nameToPartNode[UnknownParentName]; !ok {
+			parent = rootOf(nameToPartNode).AddNode(UnknownParentName)
+			nameToPartNode[UnknownParentName] = parent
+			created = append(created, parent)
+		}
+	}
+
+	var pNode = parent.AddNode(name)
+	nameToPartNode[name] = pNode
+	u.AttachedNodes = append(u.AttachedNodes, name)
+
+	return pNode, append(created, pNode), nil
+}
+
+// return a short report of affected weights, empty if nothing was affected
+func (u *UnknownNodes) Summary() string {
+	if u == nil {
+		return ""
+	}
+
+	var lines []string
+	if u.DroppedRows > 0 {
+		lines = append(lines, fmt.Sprintf("dropped %d weight rows of %d unknown nodes, total weight %d",
+			u.DroppedRows, len(u.DroppedNodes), u.DroppedWeight))
+	}
+	if len(u.AttachedNodes) > 0 {
+		var parent = u.Parent
+		if parent == "" {
+			parent = UnknownParentName
+		}
+		lines = append(lines, fmt.Sprintf("attached %d unknown nodes under %s", len(u.AttachedNodes), parent))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func rootOf(nameToPartNode map[string]*PartitionNode) *PartitionNode {
+	for _, pNode := range nameToPartNode {
+		for pNode.Parent != nil {
+			pNode = pNode.Parent
+		}
+		return pNode
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetInitialExtraSizeUnknown(t *testing.T) {
+	var weights = map[string]int64{"a": 1, "x": 2}
+	var extra = map[string][]int64{"a": {10}, "x": {20}}
+
+	for _, policy := range []UnknownNodePolicy{FailOnUnknown, DropUnknown, AttachUnknown} {
+		var root, _ = NewTree(map[string]string{"r": "r", "a": "r"})
+		var pRoot = NewPartitionTree(root)
+		var unknown = &UnknownNodes{Policy: policy}
+
+		var err = pRoot.SetInitialSize([]map[string]int64{weights}, 1, unknown)
+		if policy == FailOnUnknown {
+			var unknownErr *UnknownNodesError
+			if !errors.As(err, &unknownErr) {
+				t.Errorf("fail: error %v", err)
+			}
+			err = pRoot.SetInitialExtraSize([]map[string][]int64{extra}, 1, unknown)
+			if !errors.As(err, &unknownErr) {
+				t.Errorf("fail: extra error %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			err = pRoot.SetInitialExtraSize([]map[string][]int64{extra}, 1, unknown)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Check(pRoot).Err(); err != nil {
+			t.Error(err)
+		}
+
+		switch policy {
+		case DropUnknown:
+			if unknown.DroppedRows != 1 || unknown.DroppedWeight != 2 || len(unknown.DroppedNodes) != 1 {
+				t.Errorf("drop: %d rows of weight %d, want 1 row of weight 2", unknown.DroppedRows, unknown.DroppedWeight)
+			}
+			if pRoot.SubTreeSize != 1 || !equalVectors(pRoot.ExtraSubTreeSize, []int64{10}) {
+				t.Errorf("drop: sizes %d %v", pRoot.SubTreeSize, pRoot.ExtraSubTreeSize)
+			}
+		case AttachUnknown:
+			var nameToPartNode, _ = pRoot.MapNameToPartitionNode()
+			var x = nameToPartNode["x"]
+			if x == nil || x.Parent.Name != UnknownParentName || len(unknown.AttachedNodes) != 1 {
+				t.Fatalf("attach: attached %v", unknown.AttachedNodes)
+			}
+			if x.NodeSize != 2 || !equalVectors(x.ExtraNodeSize, []int64{20}) {
+				t.Errorf("attach: sizes of x %d %v", x.NodeSize, x.ExtraNodeSize)
+			}
+			if pRoot.SubTreeSize != 3 || !equalVectors(pRoot.ExtraSubTreeSize, []int64{30}) {
+				t.Errorf("attach: sizes %d %v", pRoot.SubTreeSize, pRoot.ExtraSubTreeSize)
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Edge is one child,parent row of a tree file. A self-parented row marks the root.
+type Edge struct {
+	Child  string
+	Parent string
+	Line   int // line of the row in its file, 0 if unknown
+}
+
+// DuplicateRows lists all rows of a child which has more than one row.
+type DuplicateRows struct {
+	Child   string
+	Parents []string // parents in the order of rows, the last one is used
+	Lines   []int
+}
+
+// conflicting rows give different parents
+func (d DuplicateRows) conflicting() bool {
+	for _, parent := range d.Parents[1:] {
+		if parent != d.Parents[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// Component is a part of the nodes which is not connected to the root.
+type Component struct {
+	Top   string // node without a row, all nodes of the component descend from it
+	Nodes int
+}
+
+// ValidationReport describes everything which prevents rows from forming one tree.
+type ValidationReport struct {
+	Nodes      int
+	Roots      []string        // self-parented nodes
+	SelfLoops  []string        // nodes having both a self-parented row and a row with another parent
+	Duplicates []DuplicateRows // children having several rows
+	Cycles     [][]string      // chains of nodes following parents, the first node is repeated at the end
+	Orphans    []Component     // components which don't descend from any root
+
+	Forest bool // several roots are allowed
+}
+
+// Valid reports whether the rows form exactly one tree, or one or more trees
+// for a forest. Duplicate rows giving the same parent are allowed.
+func (r *ValidationReport) Valid() bool {
+	for _, duplicate := range r.Duplicates {
+		if duplicate.conflicting() {
+			return false
+		}
+	}
+	if len(r.Roots) == 0 || len(r.Roots) > 1 && !r.Forest {
+		return false
+	}
+	return len(r.SelfLoops) == 0 && len(r.Cycles) == 0 && len(r.Orphans) == 0
+}
+
+// return nil for a valid tree or forest
+func (r *ValidationReport) Err() error {
+	if r.Valid() {
+		return nil
+	}
+	return r
+}
+
+// maxListed limits the number of listed problems of each kind
+const maxListed = 10
+
+func (r *ValidationReport) Error() string {
+	var lines = []string{"tree : tree is not valid"}
+	var add = func(count int, format string, item func(idx int) string) {
+		for idx := 0; idx < count && idx < maxListed; idx++ {
+			lines = append(lines, "  "+fmt.Sprintf(format, item(idx)))
+		}
+		if count > maxListed {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", count-maxListed))
+		}
+	}
+
+	switch {
+	case len(r.Roots) == 0:
+		lines = append(lines, "  no root: a root is a node whose parent is itself")
+	case len(r.Roots) > 1 && !r.Forest:
+		lines = append(lines, fmt.Sprintf("  %d roots: %s", len(r.Roots), listNames(r.Roots)))
+	}
+	add(len(r.SelfLoops), "self-loop: %s has a row with itself and with another parent", func(idx int) string {
+		return r.SelfLoops[idx]
+	})
+	var conflicts []DuplicateRows
+	for _, duplicate := range r.Duplicates {
+		if duplicate.conflicting() {
+			conflicts = append(conflicts, duplicate)
+		}
+	}
+	add(len(conflicts), "duplicate rows: %s", func(idx int) string {
+		var d = conflicts[idx]
+		return fmt.Sprintf("%s has parents %s at lines %v", d.Child, strings.Join(d.Parents, ", "), d.Lines)
+	})
+	add(len(r.Cycles), "cycle: %s", func(idx int) string {
+		return strings.Join(r.Cycles[idx], " -> ")
+	})
+	add(len(r.Orphans), "orphan component: %s", func(idx int) string {
+		return fmt.Sprintf("%d nodes under %s which has no row", r.Orphans[idx].Nodes, r.Orphans[idx].Top)
+	})
+
+	return strings.Join(lines, "\n")
+}
+
+func listNames(names []string) string {
+	if len(names) <= maxListed {
+		return strings.Join(names, ", ")
+	}
+	return strings.Join(names[:maxListed], ", ") + fmt.Sprintf(", ... and %d more", len(names)-maxListed)
+}
+
+// ValidateMap validates a child to parent map, it can't have duplicate rows.
+func ValidateMap(childToParent map[string]string) *ValidationReport {
+	var edges = make([]Edge, 0, len(childToParent))
+	for child, parent := range childToParent {
+		edges = append(edges, Edge{Child: child, Parent: parent})
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].Child < edges[j].Child })
+
+	return Validate(edges)
+}
+
+// Validate checks rows of a tree file. When a child has several rows
+// the last one is used, as it is by readers of tree files.
+func Validate(edges []Edge) *ValidationReport {
+	var report = &ValidationReport{}
+
+	var parentOf = make(map[string]string)
+	var rowsOf = make(map[string][]Edge)
+	var names []string
+	var seen = make(map[string]bool)
+	var addName = func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, edge := range edges {
+		addName(edge.Child)
+		addName(edge.Parent)
+		parentOf[edge.Child] = edge.Parent
+		rowsOf[edge.Child] = append(rowsOf[edge.Child], edge)
+	}
+	sort.Strings(names)
+	report.Nodes = len(names)
+
+	for _, name := range names {
+		var rows = rowsOf[name]
+		if parentOf[name] == name {
+			report.Roots = append(report.Roots, name)
+		}
+		if len(rows) < 2 {
+			continue
+		}
+
+		var duplicate = DuplicateRows{Child: name}
+		var isSelf, isOther bool
+		for _, row := range rows {
+			duplicate.Parents = append(duplicate.Parents, row.Parent)
+			duplicate.Lines = append(duplicate.Lines, row.Line)
+			isSelf = isSelf || row.Parent == name
+			isOther = isOther || row.Parent != name
+		}
+		report.Duplicates = append(report.Duplicates, duplicate)
+		if isSelf && isOther {
+			report.SelfLoops = append(report.SelfLoops, name)
+		}
+	}
+
+	report.findCyclesAndOrphans(names, parentOf)
+
+	return report
+}
+
+// findCyclesAndOrphans follows parents from every node. A chain ends at a root,
+// at a node without a row (top of an orphan component) or in a cycle.
+func (r *ValidationReport) findCyclesAndOrphans(names []string, parentOf map[string]string) {
+	const (
+		unvisited = iota
+		onChain
+		done
+	)
+	var state = make(map[string]int)
+	var endOf = make(map[string]string) // node -> root or orphan top, "" for cycles
+	var orphanNodes = make(map[string]int)
+
+	for _, name := range names {
+		var chain []string
+		var node = name
+		var end string
+		for {
+			if state[node] == done {
+				end = endOf[node]
+				break
+			}
+			if state[node] == onChain {
+				var start = indexOfName(chain, node)
+				var cycle = append(append([]string(nil), chain[start:]...), node)
+				r.Cycles = append(r.Cycles, cycle)
+				end = ""
+				break
+			}
+
+			state[node] = onChain
+			chain = append(chain, node)
+
+			var parent, hasRow = parentOf[node]
+			if !hasRow {
+				end = node // top of an orphan component
+				orphanNodes[end] = 0
+				break
+			}
+			if parent == node {
+				end = node
+				break
+			}
+			node = parent
+		}
+
+		for _, node := range chain {
+			state[node] = done
+			endOf[node] = end
+			if _, ok := orphanNodes[end]; ok && end != "" {
+				orphanNodes[end]++
+			}
+		}
+	}
+
+	for top, count := range orphanNodes {
+		r.Orphans = append(r.Orphans, Component{Top: top, Nodes: count})
+	}
+	sort.Slice(r.Orphans, func(i, j int) bool { return r.Orphans[i].Top < r.Orphans[j].Top })
+}
+
+func indexOfName(names []string, name string) int {
+	for idx := range names {
+		if names[idx] == name {
+			return idx
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package tree
+
+// Walkers visit nodes with an explicit stack instead of recursion,
+// so chain-like trees of any depth don't grow the goroutine stack.
+// Children are visited in their order. A visitor may change children
+// of the node it visits: pre-order walkers read them after the visit.
+
+// PreOrder visits the node and its descendants, parents before children.
+// Depth of the node is 0. Children of a node are skipped when visit returns false.
+func (node *Node) PreOrder(visit func(node *Node, depth int) bool) {
+	type item struct {
+		node  *Node
+		depth int
+	}
+	var stack = []item{{node, 0}}
+	for len(stack) > 0 {
+		var top = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !visit(top.node, top.depth) {
+			continue
+		}
+		for idx := len(top.node.Children) - 1; idx >= 0; idx-- {
+			stack = append(stack, item{top.node.Children[idx], top.depth + 1})
+		}
+	}
+}
+
+// PostOrder visits the node and its descendants, children before parents.
+func (node *Node) PostOrder(visit func(node *Node)) {
+	type frame struct {
+		node *Node
+		next int // index of the next child to walk
+	}
+	var stack = []frame{{node, 0}}
+	for len(stack) > 0 {
+		var top = &stack[len(stack)-1]
+		if top.next < len(top.node.Children) {
+			top.next++
+			stack = append(stack, frame{top.node.Children[top.next-1], 0})
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		visit(top.node)
+	}
+}
+
+// PreOrder visits the node and its descendants, parents before children.
+// Depth of the node is 0. Children of a node are skipped when visit returns false.
+func (pNode *PartitionNode) PreOrder(visit func(pNode *PartitionNode, depth int) bool) {
+	type item struct {
+		pNode *PartitionNode
+		depth int
+	}
+	var stack = []item{{pNode, 0}}
+	for len(stack) > 0 {
+		var top = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !visit(top.pNode, top.depth) {
+			continue
+		}
+		for idx := len(top.pNode.Children) - 1; idx >= 0; idx-- {
+			stack = append(stack, item{top.pNode.Children[idx], top.depth + 1})
+		}
+	}
+}
+
+// PostOrder visits the node and its descendants, children before parents.
+func (pNode *PartitionNode) PostOrder(visit func(pNode *PartitionNode)) {
+	type frame struct {
+		pNode *PartitionNode
+		next  int // index of the next child to walk
+	}
+	var stack = []frame{{pNode, 0}}
+	for len(stack) > 0 {
+		var top = &stack[len(stack)-1]
+		if top.next < len(top.pNode.Children) {
+			top.next++
+			stack = append(stack, frame{top.pNode.Children[top.next-1], 0})
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		visit(top.pNode)
+	}
+}
@@ -0,0 +1,62 @@
+package tree
+
+import (
+	"strconv"
+	"testing"
+)
+
+const chainDepth = 1000000
+
+// chain returns the child to parent map of a chain n0 <- n1 <- ... and the name of its leaf
+func chain(depth int) (map[string]string, string) {
+	var childToParent = map[string]string{"n0": "n0"}
+	for idx := 1; idx < depth; idx++ {
+		childToParent["n"+strconv.Itoa(idx)] = "n" + strconv.Itoa(idx-1)
+	}
+	return childToParent, "n" + strconv.Itoa(depth-1)
+}
+
+func TestWalkMillionDeepChain(t *testing.T) {
+	var childToParent, leaf = chain(chainDepth)
+	var root, err = NewTree(childToParent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count, maxDepth int
+	root.PreOrder(func(node *Node, depth int) bool {
+		count++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		return true
+	})
+	if count != chainDepth || maxDepth != chainDepth-1 {
+		t.Errorf("pre-order visited %d nodes up to depth %d", count, maxDepth)
+	}
+
+	var first *Node
+	root.PostOrder(func(node *Node) {
+		if first == nil {
+			first = node
+		}
+	})
+	if first == nil || first.Name != leaf {
+		t.Errorf("post-order starts with %v, want %s", first, leaf)
+	}
+
+	var pRoot = NewPartitionTree(root)
+	var weights = make(map[string]int64, chainDepth)
+	for name := range childToParent {
+		weights[name] = 1
+	}
+	if err = pRoot.SetInitialSize([]map[string]int64{weights}, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pRoot.SubTreeSize != chainDepth {
+		t.Errorf("root subtree size %d, want %d", pRoot.SubTreeSize, chainDepth)
+	}
+	if err = Check(pRoot).Err(); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/dati-mipt/dhsbpp/tree"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
 )
 
@@ -21,35 +22,38 @@ type DotNode struct {
 }
 
 func MakeVisualizationPicture(bins []*packing.Bin, pngFileName string, dirToSave string) error {
-	var treeDistribution = newDotTree(bins)
+	var treeDistribution = newDotForest(bins)
 
-	var dotFile, err = os.Create("treeDistribution.dot")
+	var dotFile, err = os.CreateTemp(dirToSave, "treeDistribution*.dot")
 	if err != nil {
 		return err
 	}
-	if err := writeTreeToDotFile(treeDistribution, dotFile); err != nil {
-		return err
+	err = writeTreeToDotFile(treeDistribution, dotFile)
+	if closeErr := dotFile.Close(); err == nil {
+		err = closeErr
 	}
-
-	if err = makePngFile(dotFile, pngFileName, dirToSave); err != nil {
-		return err
+	if err == nil {
+		err = makePngFile(dotFile, pngFileName, dirToSave)
 	}
 
-	if err = os.Remove(dotFile.Name()); err != nil {
-		return err
+	// the temporary file is removed also when writing it or dot fails
+	if removeErr := os.Remove(dotFile.Name()); err == nil {
+		err = removeErr
 	}
 
-	return nil
+	return err
 }
 
 func makePngFile(dotFile *os.File, pngFileName string, dirToSave string) error {
-	var cmd = exec.Command("dot", "-Tpng", dotFile.Name(), "-o", dirToSave+pngFileName)
+	var cmd = exec.Command("dot", "-Tpng", dotFile.Name(), "-o", filepath.Join(dirToSave, pngFileName))
 	var err = cmd.Run()
 
 	return err
 }
 
-func newDotTree(bins []*packing.Bin) *DotNode {
+// newDotForest returns roots of the bin trees, there are several of them
+// when trees of a forest are placed into different bins.
+func newDotForest(bins []*packing.Bin) []*DotNode {
 	var unconnectedDotNodes = make(map[*DotNode]bool)
 	for idx := range bins {
 		var rootNodes = bins[idx].MakeMapRootNodesOfBin()
@@ -61,13 +65,13 @@ func newDotTree(bins []*packing.Bin) *DotNode {
 		}
 	}
 
-	var rootDotNode = connectBinNodes(unconnectedDotNodes)
+	var rootDotNodes = connectBinNodes(unconnectedDotNodes)
 
-	return rootDotNode
+	return rootDotNodes
 }
 
-func connectBinNodes(unconnectedDotNodes map[*DotNode]bool) *DotNode {
-	var rootDotNode *DotNode
+func connectBinNodes(unconnectedDotNodes map[*DotNode]bool) []*DotNode {
+	var rootDotNodes []*DotNode
 
 	for dotNode := range unconnectedDotNodes {
 		var parent = findParent(dotNode, unconnectedDotNodes)
@@ -76,11 +80,14 @@ func connectBinNodes(unconnectedDotNodes map[*DotNode]bool) *DotNode {
 			dotNode.Parent = parent
 			parent.Children = append(parent.Children, dotNode)
 		} else {
-			rootDotNode = dotNode
+			rootDotNodes = append(rootDotNodes, dotNode)
 		}
 	}
+	sort.Slice(rootDotNodes, func(i, j int) bool {
+		return rootDotNodes[i].BinIndex < rootDotNodes[j].BinIndex
+	})
 
-	return rootDotNode
+	return rootDotNodes
 }
 
 func findParent(child *DotNode, dotNodes map[*DotNode]bool) *DotNode {
@@ -98,25 +105,18 @@ func findPartitionNodesOfDotNode(allPartNodes map[*tree.PartitionNode]bool,
 
 	var pNodesOfDotNode = make(map[*tree.PartitionNode]bool)
 
-	findPartitionNodesOfDotNodeFunc(pRoot, allPartNodes, pNodesOfDotNode)
+	pRoot.PreOrder(func(pNode *tree.PartitionNode, _ int) bool {
+		if ok := allPartNodes[pNode]; !ok {
+			return false
+		}
+		pNodesOfDotNode[pNode] = true
+		return true
+	})
 
 	return pNodesOfDotNode
 }
 
-func findPartitionNodesOfDotNodeFunc(pNode *tree.PartitionNode, allPartNodes map[*tree.PartitionNode]bool,
-	pNodesOfDotNode map[*tree.PartitionNode]bool) {
-	if ok, _ := allPartNodes[pNode]; !ok {
-		return
-	}
-
-	pNodesOfDotNode[pNode] = true
-
-	for _, children := range pNode.Children {
-		findPartitionNodesOfDotNodeFunc(children, allPartNodes, pNodesOfDotNode)
-	}
-}
-
-func writeTreeToDotFile(binTree *DotNode, dotFile *os.File) error {
+func writeTreeToDotFile(binTrees []*DotNode, dotFile *os.File) error {
 
 	_, err := fmt.Fprintf(dotFile,
 		"digraph G                                                          \n"+
@@ -129,17 +129,15 @@ func writeTreeToDotFile(binTree *DotNode, dotFile *os.File) error {
 		return err
 	}
 
-	err = drawNodeDot(binTree, dotFile)
-	if err != nil {
-		return err
+	for _, binTree := range binTrees {
+		if err = drawNodeDot(binTree, dotFile); err != nil {
+			return err
+		}
 	}
 
 	if _, err = fmt.Fprintf(dotFile, "}"); err != nil {
 		return err
 	}
-	if err = dotFile.Close(); err != nil {
-		return err
-	}
 
 	return nil
 }